@@ -3,14 +3,35 @@ package cryptopals
 import (
 	"bytes"
 	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
 	"fmt"
 	"log"
-	"math/rand"
+	"math/big"
+
+	cstream "github.com/harriteja/cryptopals/cipher"
 )
 
+// cryptoRandIntn returns a cryptographically random integer in [0, n),
+// used by encryptAESRandom in place of math/rand.Intn so that neither the
+// prefix/suffix lengths nor the ECB/CBC coin flip are predictable to an
+// attacker who knows the PRNG seed.
+func cryptoRandIntn(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(v.Int64()), nil
+}
+
+// encryptAESECB, decryptAESECB, encryptAESCBC, and decryptAESCBC are thin
+// shims over the streaming block-mode constructors in the cstream
+// (cryptopals/cipher) package: they keep their historical signatures and
+// no-automatic-padding behavior so every Set 2 test keeps passing
+// unmodified, while delegating the actual block chaining to cstream.
 func encryptAESECB(plainText []byte, key []byte, blockSize int) ([]byte, error) {
-	cipherText := make([]byte, len(plainText))
-	cipher, err := aes.NewCipher(key)
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("could not initialize AES: %w", err)
 	}
@@ -19,27 +40,22 @@ func encryptAESECB(plainText []byte, key []byte, blockSize int) ([]byte, error)
 		log.Printf("WARN: plainText (%d) is not a multiple of blockSize (%d)", len(plainText), blockSize)
 	}
 
-	for i := 0; i < (len(plainText) / blockSize); i++ {
-		start := i * blockSize
-		end := (i + 1) * blockSize
-		cipher.Encrypt(cipherText[start:end], plainText[start:end])
-	}
+	cipherText := make([]byte, len(plainText))
+	aligned := (len(plainText) / blockSize) * blockSize
+	cstream.NewECBEncrypter(block).CryptBlocks(cipherText[:aligned], plainText[:aligned])
 
 	return cipherText, nil
 }
 
 func decryptAESECB(cipherText []byte, key []byte, blockSize int) ([]byte, error) {
-	plainText := make([]byte, len(cipherText))
-	cipher, err := aes.NewCipher(key)
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("could not initialize AES: %w", err)
 	}
 
-	for i := 0; i < (len(plainText) / blockSize); i++ {
-		start := i * blockSize
-		end := (i + 1) * blockSize
-		cipher.Decrypt(plainText[start:end], cipherText[start:end])
-	}
+	plainText := make([]byte, len(cipherText))
+	aligned := (len(plainText) / blockSize) * blockSize
+	cstream.NewECBDecrypter(block).CryptBlocks(plainText[:aligned], cipherText[:aligned])
 
 	return plainText, nil
 }
@@ -54,26 +70,14 @@ func encryptAESCBC(plainText []byte, key []byte, iv []byte) ([]byte, error) {
 		return nil, fmt.Errorf("iv size must be %d", blockSize)
 	}
 
-	cipherText := make([]byte, len(plainText))
-	cipher, err := aes.NewCipher(key)
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("could not initialize AES: %w", err)
 	}
 
-	buffer := make([]byte, blockSize)
-	lastCipherText := make([]byte, blockSize)
-	copy(lastCipherText, iv)
-	for i := 0; i < (len(plainText) / blockSize); i++ {
-		start := i * blockSize
-		end := (i + 1) * blockSize
-
-		for j := 0; j < blockSize; j++ {
-			buffer[j] = lastCipherText[j] ^ plainText[start:end][j]
-		}
-
-		cipher.Encrypt(lastCipherText, buffer)
-		copy(cipherText[start:end], lastCipherText)
-	}
+	cipherText := make([]byte, len(plainText))
+	aligned := (len(plainText) / blockSize) * blockSize
+	cstream.NewCBCEncrypter(block, iv[:blockSize]).CryptBlocks(cipherText[:aligned], plainText[:aligned])
 
 	return cipherText, nil
 }
@@ -88,83 +92,431 @@ func decryptAESCBC(cipherText []byte, key []byte, iv []byte) ([]byte, error) {
 		return nil, fmt.Errorf("iv size must be %d", blockSize)
 	}
 
-	cipher, err := aes.NewCipher(key)
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("could not initialize AES: %w", err)
 	}
 
 	plainText := make([]byte, len(cipherText))
-	buffer := make([]byte, blockSize)
-	plainTextBuffer := make([]byte, blockSize)
-	lastCipherText := make([]byte, blockSize)
-	copy(lastCipherText, iv)
-	for i := 0; i < (len(plainText) / blockSize); i++ {
-		start := i * blockSize
-		end := (i + 1) * blockSize
-
-		cipher.Decrypt(buffer, cipherText[start:end])
-		for j := 0; j < blockSize; j++ {
-			plainTextBuffer[j] = lastCipherText[j] ^ buffer[j]
+	aligned := (len(plainText) / blockSize) * blockSize
+	cstream.NewCBCDecrypter(block, iv[:blockSize]).CryptBlocks(plainText[:aligned], cipherText[:aligned])
+
+	return plainText, nil
+}
+
+// gf128Double multiplies a 16-byte block by 2 in GF(2^128), the doubling
+// operation shared by EME and CMAC: a left shift by one bit, with the
+// reduction constant 0x87 XORed into the low byte whenever the top bit of
+// the input was set.
+func gf128Double(b []byte) []byte {
+	out := make([]byte, 16)
+	for i := 0; i < 16; i++ {
+		out[i] = b[i] << 1
+		if i < 15 {
+			out[i] |= b[i+1] >> 7
+		}
+	}
+	if b[0]&0x80 != 0 {
+		out[15] ^= 0x87
+	}
+	return out
+}
+
+func xor16(a, b []byte) []byte {
+	out := make([]byte, 16)
+	for i := range out {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
+
+// encryptAESEME implements Halevi-Rogaway EME (ECB-Mix-ECB), a wide-block
+// mode built entirely out of AES-ECB calls: unlike encryptAESECB, two
+// identical 16-byte plaintext blocks never produce identical ciphertext
+// blocks, since every block in the message affects every other block's
+// output. plainText must be 1-128 whole 16-byte blocks; tweak may be nil,
+// which is treated as the all-zero tweak.
+func encryptAESEME(plainText []byte, key []byte, tweak []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize AES: %w", err)
+	}
+	if len(key) != 16 {
+		return nil, fmt.Errorf("EME requires a 16-byte key, got %d", len(key))
+	}
+	if len(plainText) == 0 || len(plainText)%16 != 0 {
+		return nil, fmt.Errorf("EME requires block-aligned plaintext, got %d bytes", len(plainText))
+	}
+
+	m := len(plainText) / 16
+	if m > 128 {
+		return nil, fmt.Errorf("EME supports at most 128 blocks, got %d", m)
+	}
+
+	if tweak == nil {
+		tweak = make([]byte, 16)
+	}
+	if len(tweak) != 16 {
+		return nil, fmt.Errorf("tweak must be 16 bytes, got %d", len(tweak))
+	}
+
+	zero := make([]byte, 16)
+	lBuf := make([]byte, 16)
+	block.Encrypt(lBuf, zero)
+	L := gf128Double(lBuf)
+
+	// powL[j] = 2^j * L, so PPP_i (1-indexed) uses powL[i-1].
+	powL := make([][]byte, m)
+	powL[0] = L
+	for j := 1; j < m; j++ {
+		powL[j] = gf128Double(powL[j-1])
+	}
+
+	PPP := make([][]byte, m)
+	mp := append([]byte{}, tweak...)
+	for j := 0; j < m; j++ {
+		ppp := make([]byte, 16)
+		block.Encrypt(ppp, xor16(plainText[j*16:(j+1)*16], powL[j]))
+		PPP[j] = ppp
+		mp = xor16(mp, ppp)
+	}
+
+	MC := make([]byte, 16)
+	block.Encrypt(MC, mp)
+	M := xor16(mp, MC)
+
+	powM := make([][]byte, m)
+	if m > 1 {
+		powM[1] = gf128Double(M)
+		for j := 2; j < m; j++ {
+			powM[j] = gf128Double(powM[j-1])
 		}
+	}
+
+	CCC := make([][]byte, m)
+	cipherText := make([]byte, len(plainText))
+	for j := 1; j < m; j++ {
+		CCC[j] = xor16(PPP[j], powM[j])
+	}
+
+	CCC[0] = append([]byte{}, MC...)
+	CCC[0] = xor16(CCC[0], tweak)
+	for j := 1; j < m; j++ {
+		CCC[0] = xor16(CCC[0], CCC[j])
+	}
 
-		copy(plainText[start:end], plainTextBuffer)
-		copy(lastCipherText, cipherText[start:end])
+	for j := 0; j < m; j++ {
+		c := make([]byte, 16)
+		block.Encrypt(c, CCC[j])
+		copy(cipherText[j*16:(j+1)*16], xor16(c, powL[j]))
+	}
+
+	return cipherText, nil
+}
+
+// decryptAESEME reverses encryptAESEME. It mirrors the encryption
+// algorithm exactly, substituting AES decryption for encryption at every
+// step, per Halevi-Rogaway.
+func decryptAESEME(cipherText []byte, key []byte, tweak []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize AES: %w", err)
+	}
+	if len(key) != 16 {
+		return nil, fmt.Errorf("EME requires a 16-byte key, got %d", len(key))
+	}
+	if len(cipherText) == 0 || len(cipherText)%16 != 0 {
+		return nil, fmt.Errorf("EME requires block-aligned ciphertext, got %d bytes", len(cipherText))
+	}
+
+	m := len(cipherText) / 16
+	if m > 128 {
+		return nil, fmt.Errorf("EME supports at most 128 blocks, got %d", m)
+	}
+
+	if tweak == nil {
+		tweak = make([]byte, 16)
+	}
+	if len(tweak) != 16 {
+		return nil, fmt.Errorf("tweak must be 16 bytes, got %d", len(tweak))
+	}
+
+	zero := make([]byte, 16)
+	lBuf := make([]byte, 16)
+	block.Encrypt(lBuf, zero)
+	L := gf128Double(lBuf)
+
+	powL := make([][]byte, m)
+	powL[0] = L
+	for j := 1; j < m; j++ {
+		powL[j] = gf128Double(powL[j-1])
+	}
+
+	CCC := make([][]byte, m)
+	for j := 0; j < m; j++ {
+		ccc := make([]byte, 16)
+		block.Decrypt(ccc, xor16(cipherText[j*16:(j+1)*16], powL[j]))
+		CCC[j] = ccc
+	}
+
+	MC := append([]byte{}, CCC[0]...)
+	MC = xor16(MC, tweak)
+	for j := 1; j < m; j++ {
+		MC = xor16(MC, CCC[j])
+	}
+
+	mp := make([]byte, 16)
+	block.Decrypt(mp, MC)
+	M := xor16(mp, MC)
+
+	powM := make([][]byte, m)
+	if m > 1 {
+		powM[1] = gf128Double(M)
+		for j := 2; j < m; j++ {
+			powM[j] = gf128Double(powM[j-1])
+		}
+	}
+
+	PPP := make([][]byte, m)
+	for j := 1; j < m; j++ {
+		PPP[j] = xor16(CCC[j], powM[j])
+	}
+
+	PPP[0] = append([]byte{}, mp...)
+	PPP[0] = xor16(PPP[0], tweak)
+	for j := 1; j < m; j++ {
+		PPP[0] = xor16(PPP[0], PPP[j])
+	}
+
+	plainText := make([]byte, len(cipherText))
+	for j := 0; j < m; j++ {
+		p := make([]byte, 16)
+		block.Decrypt(p, PPP[j])
+		copy(plainText[j*16:(j+1)*16], xor16(p, powL[j]))
 	}
 
 	return plainText, nil
 }
 
-// Encrypts plainText under an unknown key, using ECB 50% of the time
-// and CBC (with a random IV) 50% of the time (randomly.)
-func encryptAESRandom(plainText []byte) ([]byte, error) {
-	key := make([]byte, 16)
-	_, err := rand.Read(key)
+// cmacBlock computes NIST SP 800-38B CMAC over msg using an
+// already-initialized block cipher, so EAX below can compute the three
+// CMACs it needs without re-expanding the AES key each time.
+func cmacBlock(block cipher.Block, msg []byte) []byte {
+	zero := make([]byte, 16)
+	l := make([]byte, 16)
+	block.Encrypt(l, zero)
+	k1 := gf128Double(l)
+	k2 := gf128Double(k1)
+
+	n := (len(msg) + 15) / 16
+	if n == 0 {
+		n = 1
+	}
+	complete := len(msg) > 0 && len(msg)%16 == 0
+
+	mac := make([]byte, 16)
+	for i := 0; i < n-1; i++ {
+		block.Encrypt(mac, xor16(mac, msg[i*16:(i+1)*16]))
+	}
+
+	last := make([]byte, 16)
+	if complete {
+		last = xor16(msg[(n-1)*16:n*16], k1)
+	} else {
+		copy(last, msg[(n-1)*16:])
+		last[len(msg)-(n-1)*16] = 0x80
+		last = xor16(last, k2)
+	}
+
+	block.Encrypt(mac, xor16(mac, last))
+	return mac
+}
 
+// cmacAES computes NIST SP 800-38B CMAC over msg under key, an AES-based
+// alternative to HMAC that needs no separate hash function.
+func cmacAES(key, msg []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
-		return nil, fmt.Errorf("Can't generate random key: %w", err)
+		return nil, fmt.Errorf("could not initialize AES: %w", err)
 	}
 
-	iv := make([]byte, 16)
-	_, err = rand.Read(iv)
+	return cmacBlock(block, msg), nil
+}
 
+// eaxOMAC computes the "tweaked" CMAC variant EAX uses to separate the
+// nonce, header, and ciphertext MACs: CMAC(t||msg), where t is a 16-byte
+// block carrying nothing but the tweak index in its final byte.
+func eaxOMAC(block cipher.Block, t byte, msg []byte) []byte {
+	tweak := make([]byte, 16)
+	tweak[15] = t
+	return cmacBlock(block, append(tweak, msg...))
+}
+
+// encryptAESEAX implements EAX mode (Bellare-Rogaway-Wagner), an
+// authenticated mode built entirely out of CMAC and AES-CTR: N
+// authenticates the nonce, H authenticates the header/associated data,
+// and the returned tag additionally authenticates the ciphertext itself,
+// so (unlike plain CBC) flipping any ciphertext bit is detected rather
+// than silently producing garbled plaintext.
+func encryptAESEAX(key, nonce, header, plainText []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
+		return nil, fmt.Errorf("could not initialize AES: %w", err)
+	}
+
+	n := eaxOMAC(block, 0, nonce)
+	h := eaxOMAC(block, 1, header)
+
+	cipherText := make([]byte, len(plainText))
+	cipher.NewCTR(block, n).XORKeyStream(cipherText, plainText)
+
+	c := eaxOMAC(block, 2, cipherText)
+	tag := xor16(n, xor16(h, c))
+
+	return append(cipherText, tag...), nil
+}
+
+// decryptAESEAX reverses encryptAESEAX, verifying the tag in constant
+// time before decrypting so a tampered ciphertext never reaches the
+// caller as (incorrect) plaintext.
+func decryptAESEAX(key, nonce, header, sealed []byte) ([]byte, error) {
+	if len(sealed) < 16 {
+		return nil, fmt.Errorf("EAX ciphertext too short to contain a tag")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize AES: %w", err)
+	}
+
+	cipherText, tag := sealed[:len(sealed)-16], sealed[len(sealed)-16:]
+
+	n := eaxOMAC(block, 0, nonce)
+	h := eaxOMAC(block, 1, header)
+	c := eaxOMAC(block, 2, cipherText)
+	wantTag := xor16(n, xor16(h, c))
+
+	if subtle.ConstantTimeCompare(tag, wantTag) != 1 {
+		return nil, fmt.Errorf("EAX: message authentication failed")
+	}
+
+	plainText := make([]byte, len(cipherText))
+	cipher.NewCTR(block, n).XORKeyStream(plainText, cipherText)
+
+	return plainText, nil
+}
+
+// RandomMode names which mode encryptAESRandom chose for a given call.
+type RandomMode int
+
+const (
+	ModeECB RandomMode = iota
+	ModeCBC
+)
+
+func (m RandomMode) String() string {
+	if m == ModeCBC {
+		return "CBC"
+	}
+	return "ECB"
+}
+
+// RandomCiphertext is the result of encryptAESRandom: the ciphertext plus
+// the ground truth needed to check a detector's accuracy, rather than
+// having the caller infer it from console output.
+type RandomCiphertext struct {
+	Ciphertext []byte
+	Mode       RandomMode
+	IV         []byte
+}
+
+// Encrypts plainText under an unknown key, using ECB 50% of the time
+// and CBC (with a random IV) 50% of the time (randomly.) All randomness
+// (key, IV, prefix/suffix lengths, and the mode coin flip) comes from
+// crypto/rand, since this is meant to model an oracle an attacker cannot
+// predict or bias.
+func encryptAESRandom(plainText []byte) (*RandomCiphertext, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("Can't generate random key: %w", err)
+	}
+
+	iv := make([]byte, 16)
+	if _, err := rand.Read(iv); err != nil {
 		return nil, fmt.Errorf("Can't generate random IV: %w", err)
 	}
 
-	beforeData := make([]byte, rand.Intn(5)+5)
-	_, err = rand.Read(beforeData)
+	beforeExtra, err := cryptoRandIntn(5)
 	if err != nil {
+		return nil, fmt.Errorf("Can't generate random prefix length: %w", err)
+	}
+	beforeData := make([]byte, beforeExtra+5)
+	if _, err := rand.Read(beforeData); err != nil {
 		return nil, fmt.Errorf("Can't generate random prefix data: %w", err)
 	}
 
-	afterData := make([]byte, rand.Intn(5)+5)
-	_, err = rand.Read(key)
+	afterExtra, err := cryptoRandIntn(5)
 	if err != nil {
+		return nil, fmt.Errorf("Can't generate random suffix length: %w", err)
+	}
+	afterData := make([]byte, afterExtra+5)
+	if _, err := rand.Read(afterData); err != nil {
 		return nil, fmt.Errorf("Can't generate random suffix data: %w", err)
 	}
 
 	newPlainText := append(append(beforeData, plainText...), afterData...)
 
-	toss := rand.Intn(2)
-	var cipherText []byte
+	toss, err := cryptoRandIntn(2)
+	if err != nil {
+		return nil, fmt.Errorf("Can't flip mode coin: %w", err)
+	}
 
-	if toss == 0 {
-		fmt.Println("ECB")
-		// Mode ECB
+	mode := ModeECB
+	if toss == 1 {
+		mode = ModeCBC
+	}
+
+	var cipherText []byte
+	switch mode {
+	case ModeECB:
 		cipherText, err = encryptAESECB(newPlainText, key, 16)
 		if err != nil {
 			return nil, fmt.Errorf("Could not perform ECB encryption: %w", err)
 		}
-	} else {
-		fmt.Println("CBC")
-		// Mode CBC
+	case ModeCBC:
 		cipherText, err = encryptAESCBC(newPlainText, key, iv)
 		if err != nil {
 			return nil, fmt.Errorf("Could not perform CBC encryption: %w", err)
 		}
 	}
 
-	return cipherText, nil
+	return &RandomCiphertext{Ciphertext: cipherText, Mode: mode, IV: iv}, nil
+}
+
+// DetectMode scores how ECB-like ct looks by summing the squared
+// occurrence count of each blockSize-byte block. With no repeated
+// blocks, that sum equals the number of blocks; any repetition -- the
+// signature of ECB on structured or repetitive plaintext -- pushes the
+// sum strictly higher, since n duplicate blocks contribute n^2 rather
+// than n. This replaces the old aliasing-prone "largest block size with
+// any similar block" heuristic noted in TestS2C11's FIXME.
+func DetectMode(ct []byte, blockSize int) RandomMode {
+	counts := map[string]int{}
+	numBlocks := 0
+	for i := 0; i+blockSize <= len(ct); i += blockSize {
+		counts[string(ct[i:i+blockSize])]++
+		numBlocks++
+	}
+
+	score := 0
+	for _, c := range counts {
+		score += c * c
+	}
+
+	if score > numBlocks {
+		return ModeECB
+	}
+	return ModeCBC
 }
 
 type encryptor func([]byte) ([]byte, error)
@@ -0,0 +1,440 @@
+package cryptopals
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/dsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/big"
+
+	cstream "github.com/harriteja/cryptopals/cipher"
+)
+
+// akeG/akeP are the same 1536-bit MODP group used elsewhere in this
+// repo for Diffie-Hellman (RFC 3526 group 5, generator 2).
+var (
+	akeG = big.NewInt(2)
+	akeP = func() *big.Int {
+		p, _ := new(big.Int).SetString("ffffffffffffffffc90fdaa22168c234c4c6628b80dc1cd129024e088a67cc74020bbea63b139b22514a08798e3404ddef9519b3cd3a431b302b0a6df25f14374fe1356d6d51c245e485b576625e7ec6f44c42e9a637ed6b0bff5cb6f406b7edee386bfb5a899fa5ae9f24117c4b1fe649286651ece45b3dc2007cb8a163bf0598da48361c55d39a69163fa8fd24cf5f83655d23dca3ad961c62f356208552bb9ed529077096966d670c354e4abc9804f1746c08ca237327ffffffffffffffff", 16)
+		return p
+	}()
+)
+
+type akeRole int
+
+const (
+	akeInitiator akeRole = iota
+	akeResponder
+)
+
+// AKEState drives one side of an OTR-style authenticated key exchange: a
+// commit/dh-key/reveal-signature/signature handshake that binds the
+// ephemeral DH exponents to each party's long-term DSA key, so that the raw
+// parameter-injection MITM used against TestS5C34/TestS5C35 is detectable
+// instead of silently succeeding. Create one with NewInitiator or
+// NewResponder and drive it through Commit/DHKey/RevealSig/Sig/VerifySig in
+// that order; SessionKey is populated once the exchange completes
+// successfully.
+type AKEState struct {
+	role akeRole
+
+	priv            *dsa.PrivateKey
+	peerFingerprint []byte
+	peerPub         *dsa.PublicKey
+
+	x, gx *big.Int
+	y, gy *big.Int
+	r     []byte // AES-CTR key the initiator commits to gx under
+
+	encryptedGx []byte
+	hashedGx    []byte
+
+	s       *big.Int
+	c, cp   []byte
+	m1, m1p []byte
+	m2, m2p []byte
+
+	// SessionKey is set once the handshake has been fully verified.
+	SessionKey []byte
+}
+
+// NewInitiator creates the side of the exchange that picks x and commits to
+// g^x first. peerFingerprint, if non-nil, pins the expected SHA256 of the
+// peer's DSA public key (trust-on-first-use); pass nil to accept whatever
+// key the peer presents.
+func NewInitiator(priv *dsa.PrivateKey, peerFingerprint []byte) *AKEState {
+	return &AKEState{role: akeInitiator, priv: priv, peerFingerprint: peerFingerprint}
+}
+
+// NewResponder creates the side of the exchange that replies with g^y.
+func NewResponder(priv *dsa.PrivateKey, peerFingerprint []byte) *AKEState {
+	return &AKEState{role: akeResponder, priv: priv, peerFingerprint: peerFingerprint}
+}
+
+func akeRandExponent() (*big.Int, error) {
+	r, err := rand.Int(rand.Reader, new(big.Int).Sub(akeP, big.NewInt(2)))
+	if err != nil {
+		return nil, err
+	}
+	return r.Add(r, big.NewInt(1)), nil
+}
+
+// Commit is the initiator's first message: pick x, and send AES-CTR(r, g^x)
+// alongside SHA256(g^x) so g^x itself isn't revealed until RevealSig, but
+// the responder can later check it wasn't changed in transit.
+func (a *AKEState) Commit() ([]byte, error) {
+	if a.role != akeInitiator {
+		return nil, errors.New("ake: Commit is only valid for the initiator")
+	}
+
+	x, err := akeRandExponent()
+	if err != nil {
+		return nil, err
+	}
+	a.x = x
+	a.gx = bigModExp(akeG, x, akeP)
+
+	a.r = make([]byte, 16)
+	if _, err := rand.Read(a.r); err != nil {
+		return nil, err
+	}
+
+	encryptedGx, err := aesCTRCrypt(a.r, make([]byte, 16), a.gx.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	a.encryptedGx = encryptedGx
+
+	hashed := sha256.Sum256(a.gx.Bytes())
+	a.hashedGx = hashed[:]
+
+	return akeEncode(a.encryptedGx, a.hashedGx), nil
+}
+
+// DHKey is the responder's reply to Commit: pick y and send g^y.
+func (a *AKEState) DHKey(commitMsg []byte) ([]byte, error) {
+	if a.role != akeResponder {
+		return nil, errors.New("ake: DHKey is only valid for the responder")
+	}
+
+	parts, err := akeDecode(commitMsg, 2)
+	if err != nil {
+		return nil, err
+	}
+	a.encryptedGx, a.hashedGx = parts[0], parts[1]
+
+	y, err := akeRandExponent()
+	if err != nil {
+		return nil, err
+	}
+	a.y = y
+	a.gy = bigModExp(akeG, y, akeP)
+
+	return akeEncode(a.gy.Bytes()), nil
+}
+
+// RevealSig is the initiator's third message: reveal r and g^x, then send a
+// DSA signature over the transcript, encrypted and MAC'd under keys derived
+// from the shared secret s = (g^y)^x.
+func (a *AKEState) RevealSig(dhKeyMsg []byte) ([]byte, error) {
+	if a.role != akeInitiator {
+		return nil, errors.New("ake: RevealSig is only valid for the initiator")
+	}
+
+	parts, err := akeDecode(dhKeyMsg, 1)
+	if err != nil {
+		return nil, err
+	}
+	a.gy = new(big.Int).SetBytes(parts[0])
+	if !akeValidPublicValue(a.gy) {
+		return nil, errors.New("ake: g^y is a degenerate group element")
+	}
+
+	a.s = bigModExp(a.gy, a.x, akeP)
+	a.deriveKeys()
+
+	sigMsg, err := a.signTranscript(a.gx, a.gy)
+	if err != nil {
+		return nil, err
+	}
+
+	return akeEncode(a.r, a.gx.Bytes(), sigMsg), nil
+}
+
+// Sig is the responder's final message: verify the committed g^x and the
+// initiator's signature, then reply with the responder's own signature over
+// the swapped transcript.
+func (a *AKEState) Sig(revealSigMsg []byte) ([]byte, error) {
+	if a.role != akeResponder {
+		return nil, errors.New("ake: Sig is only valid for the responder")
+	}
+
+	parts, err := akeDecode(revealSigMsg, 3)
+	if err != nil {
+		return nil, err
+	}
+	r, gxBytes, sigMsg := parts[0], parts[1], parts[2]
+
+	revealedGx, err := aesCTRCrypt(r, make([]byte, 16), a.encryptedGx)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(revealedGx, gxBytes) {
+		return nil, errors.New("ake: revealed g^x does not match the value committed to in Commit")
+	}
+	if got := sha256.Sum256(gxBytes); !bytes.Equal(got[:], a.hashedGx) {
+		return nil, errors.New("ake: g^x does not match its commitment hash")
+	}
+	a.gx = new(big.Int).SetBytes(gxBytes)
+	if !akeValidPublicValue(a.gx) {
+		return nil, errors.New("ake: g^x is a degenerate group element")
+	}
+
+	a.s = bigModExp(a.gx, a.y, akeP)
+	a.deriveKeys()
+
+	if err := a.verifyTranscript(sigMsg, a.gx, a.gy); err != nil {
+		return nil, err
+	}
+
+	ourSigMsg, err := a.signTranscript(a.gy, a.gx)
+	if err != nil {
+		return nil, err
+	}
+
+	a.SessionKey = append([]byte(nil), a.s.Bytes()...)
+	return ourSigMsg, nil
+}
+
+// VerifySig is the initiator's last step: verify the responder's signature
+// over the swapped transcript and finish the exchange.
+func (a *AKEState) VerifySig(sigMsg []byte) error {
+	if a.role != akeInitiator {
+		return errors.New("ake: VerifySig is only valid for the initiator")
+	}
+
+	if err := a.verifyTranscript(sigMsg, a.gy, a.gx); err != nil {
+		return err
+	}
+
+	a.SessionKey = append([]byte(nil), a.s.Bytes()...)
+	return nil
+}
+
+// akeValidPublicValue rejects the degenerate DH public values (1, p-1, and
+// by extension anything g could be forced to if g itself were injected as 1
+// or p-1) that the S5C35 MITM relies on.
+func akeValidPublicValue(v *big.Int) bool {
+	if v.Cmp(big.NewInt(1)) <= 0 {
+		return false
+	}
+	pMinus1 := new(big.Int).Sub(akeP, big.NewInt(1))
+	return v.Cmp(pMinus1) < 0
+}
+
+// deriveKeys expands the shared secret s into the six direction-separated
+// keys real OTR uses: (c, m1, m2) for the message one side encrypts and the
+// other decrypts, and (c', m1', m2') for the reverse direction. Deriving a
+// single c/m1/m2 pair from s (as an earlier version of this did) gives both
+// sides the identical AES-CTR keystream under the same all-zero IV, so an
+// eavesdropper can XOR the two RevealSig/Sig payloads together and recover
+// payloadA XOR payloadB without ever learning s. Splitting by direction
+// means the initiator and responder never encrypt under the same key, so
+// that XOR trick no longer applies.
+func (a *AKEState) deriveKeys() {
+	sBytes := a.s.Bytes()
+	a.c = akeKDF(sBytes, 1)[:16]
+	a.cp = akeKDF(sBytes, 2)[:16]
+	a.m1 = akeKDF(sBytes, 3)
+	a.m1p = akeKDF(sBytes, 4)
+	a.m2 = akeKDF(sBytes, 5)
+	a.m2p = akeKDF(sBytes, 6)
+}
+
+// encKeys returns the (c, m1, m2) triple this role encrypts its own
+// signTranscript payload under; peerEncKeys returns the triple the peer
+// used for the payload this role verifies in verifyTranscript. The
+// initiator and responder always pick the opposite triple from each other,
+// so the two sides never share an AES-CTR key.
+func (a *AKEState) encKeys() (c, m1, m2 []byte) {
+	if a.role == akeInitiator {
+		return a.c, a.m1, a.m2
+	}
+	return a.cp, a.m1p, a.m2p
+}
+
+func (a *AKEState) peerEncKeys() (c, m1, m2 []byte) {
+	if a.role == akeInitiator {
+		return a.cp, a.m1p, a.m2p
+	}
+	return a.c, a.m1, a.m2
+}
+
+func akeKDF(s []byte, tag byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{tag})
+	h.Write(s)
+	return h.Sum(nil)
+}
+
+// signTranscript signs MB = MAC_m1(first, second, ourPubKey) and returns
+// E_c(pubKey || sig) || MAC_m2(E_c(...)), using this role's own (c, m1, m2)
+// -- the initiator and responder each encrypt under a different key, so
+// the two sides' payloads never share a keystream.
+func (a *AKEState) signTranscript(first, second *big.Int) ([]byte, error) {
+	c, m1, m2 := a.encKeys()
+
+	pubBytes := encodeDSAPublicKey(&a.priv.PublicKey)
+	mb := akeHMAC(m1, first.Bytes(), second.Bytes(), pubBytes)
+
+	sigR, sigS, err := dsa.Sign(rand.Reader, a.priv, mb)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := akeEncode(pubBytes, akeEncode(sigR.Bytes(), sigS.Bytes()))
+	encSig, err := aesCTRCrypt(c, make([]byte, 16), payload)
+	if err != nil {
+		return nil, err
+	}
+	mac := akeHMAC(m2, encSig)
+
+	return akeEncode(encSig, mac), nil
+}
+
+// verifyTranscript checks a message produced by the peer's signTranscript
+// against MB = MAC_m1(first, second, peerPubKey), pinning the peer's
+// fingerprint on first use if one wasn't already configured. It decrypts
+// with the peer's (c, m1, m2) triple -- the opposite of the one
+// signTranscript used to produce our own messages.
+func (a *AKEState) verifyTranscript(sigMsg []byte, first, second *big.Int) error {
+	c, m1, m2 := a.peerEncKeys()
+
+	parts, err := akeDecode(sigMsg, 2)
+	if err != nil {
+		return err
+	}
+	encSig, mac := parts[0], parts[1]
+
+	wantMAC := akeHMAC(m2, encSig)
+	if !hmac.Equal(wantMAC, mac) {
+		return errors.New("ake: signature MAC mismatch")
+	}
+
+	payload, err := aesCTRCrypt(c, make([]byte, 16), encSig)
+	if err != nil {
+		return err
+	}
+	payloadParts, err := akeDecode(payload, 2)
+	if err != nil {
+		return err
+	}
+	pubBytes, sigBytes := payloadParts[0], payloadParts[1]
+
+	fp := sha256.Sum256(pubBytes)
+	if a.peerFingerprint != nil {
+		if !hmac.Equal(fp[:], a.peerFingerprint) {
+			return errors.New("ake: peer DSA key does not match pinned fingerprint")
+		}
+	} else {
+		a.peerFingerprint = fp[:]
+	}
+
+	peerPub, err := decodeDSAPublicKey(pubBytes)
+	if err != nil {
+		return err
+	}
+	a.peerPub = peerPub
+
+	sigParts, err := akeDecode(sigBytes, 2)
+	if err != nil {
+		return err
+	}
+	sigR := new(big.Int).SetBytes(sigParts[0])
+	sigS := new(big.Int).SetBytes(sigParts[1])
+
+	mb := akeHMAC(m1, first.Bytes(), second.Bytes(), pubBytes)
+	if !dsa.Verify(peerPub, mb, sigR, sigS) {
+		return errors.New("ake: signature verification failed")
+	}
+
+	return nil
+}
+
+func akeHMAC(key []byte, parts ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, p := range parts {
+		mac.Write(p)
+	}
+	return mac.Sum(nil)
+}
+
+func encodeDSAPublicKey(pub *dsa.PublicKey) []byte {
+	return akeEncode(pub.P.Bytes(), pub.Q.Bytes(), pub.G.Bytes(), pub.Y.Bytes())
+}
+
+func decodeDSAPublicKey(data []byte) (*dsa.PublicKey, error) {
+	parts, err := akeDecode(data, 4)
+	if err != nil {
+		return nil, err
+	}
+	return &dsa.PublicKey{
+		Parameters: dsa.Parameters{
+			P: new(big.Int).SetBytes(parts[0]),
+			Q: new(big.Int).SetBytes(parts[1]),
+			G: new(big.Int).SetBytes(parts[2]),
+		},
+		Y: new(big.Int).SetBytes(parts[3]),
+	}, nil
+}
+
+// aesCTRCrypt runs a single CTR keystream pass over data (the same
+// operation encrypts and decrypts) using the cipher package's CTR Reader.
+// Every caller in this file passes an all-zero IV, since each key is
+// single-use per handshake.
+func aesCTRCrypt(key, iv, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	r := cstream.NewCTRReader(bytes.NewReader(data), block, iv)
+	out := make([]byte, len(data))
+	if _, err := io.ReadFull(r, out); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return out, nil
+}
+
+func akeEncode(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		length := len(p)
+		out = append(out, byte(length>>24), byte(length>>16), byte(length>>8), byte(length))
+		out = append(out, p...)
+	}
+	return out
+}
+
+func akeDecode(data []byte, n int) ([][]byte, error) {
+	out := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		if len(data) < 4 {
+			return nil, errors.New("ake: truncated message")
+		}
+		length := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+		data = data[4:]
+		if len(data) < length {
+			return nil, errors.New("ake: truncated message")
+		}
+		out = append(out, data[:length])
+		data = data[length:]
+	}
+	if len(data) != 0 {
+		return nil, errors.New("ake: trailing bytes in message")
+	}
+	return out, nil
+}
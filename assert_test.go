@@ -0,0 +1,43 @@
+package cryptopals
+
+import "testing"
+
+// assertNoError fails the test if err is non-nil.
+func assertNoError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// assertHasError fails the test if err is nil.
+func assertHasError(t *testing.T, err error) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("expected an error, got nil")
+	}
+}
+
+// assertTrue fails the test if b is false.
+func assertTrue(t *testing.T, b bool) {
+	t.Helper()
+	if !b {
+		t.Fatalf("expected true, got false")
+	}
+}
+
+// assertFalse fails the test if b is true.
+func assertFalse(t *testing.T, b bool) {
+	t.Helper()
+	if b {
+		t.Fatalf("expected false, got true")
+	}
+}
+
+// assertEquals fails the test if want and got aren't equal.
+func assertEquals(t *testing.T, want, got interface{}) {
+	t.Helper()
+	if want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
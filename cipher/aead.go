@@ -0,0 +1,63 @@
+package cipher
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+)
+
+// AEAD wraps an authenticated mode (GCM or CCM) with a random-nonce
+// Seal/Open pair, matching the "IV prepended to the ciphertext by
+// default" convention used by Writer/Reader in stream.go.
+type AEAD struct {
+	aead cipher.AEAD
+}
+
+// NewGCM returns an AEAD backed by AES-GCM.
+func NewGCM(block cipher.Block) (*AEAD, error) {
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize GCM: %w", err)
+	}
+	return &AEAD{aead: gcm}, nil
+}
+
+// NewCCM returns an AEAD backed by AES-CCM (NIST SP 800-38C), with the
+// default 12-byte nonce and 16-byte tag used by the Cryptopals labs. Go's
+// standard library has no CCM implementation, so ccmAEAD in ccm.go
+// provides one built directly on cipher.Block.
+func NewCCM(block cipher.Block) (*AEAD, error) {
+	ccm, err := newCCM(block, ccmNonceSize, ccmTagSize)
+	if err != nil {
+		return nil, err
+	}
+	return &AEAD{aead: ccm}, nil
+}
+
+// Seal encrypts and authenticates plaintext, generating a fresh random
+// nonce with crypto/rand and prepending it to the returned ciphertext.
+func (a *AEAD) Seal(plainText, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, a.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("could not generate nonce: %w", err)
+	}
+
+	return a.aead.Seal(nonce, nonce, plainText, additionalData), nil
+}
+
+// Open splits the nonce prepended by Seal off of cipherText, then
+// verifies and decrypts the remainder.
+func (a *AEAD) Open(cipherText, additionalData []byte) ([]byte, error) {
+	ns := a.aead.NonceSize()
+	if len(cipherText) < ns {
+		return nil, fmt.Errorf("ciphertext shorter than nonce")
+	}
+
+	nonce, ct := cipherText[:ns], cipherText[ns:]
+	plainText, err := a.aead.Open(nil, nonce, ct, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("could not authenticate/decrypt: %w", err)
+	}
+
+	return plainText, nil
+}
@@ -0,0 +1,116 @@
+package cipher
+
+import (
+	"crypto/cipher"
+)
+
+// ecb implements cipher.BlockMode for ECB, which crypto/cipher does not
+// expose directly (Go's stdlib authors consider it unsafe for general use,
+// but the Cryptopals challenges are specifically about its weaknesses).
+type ecb struct {
+	b         cipher.Block
+	blockSize int
+}
+
+func newECB(b cipher.Block) *ecb {
+	return &ecb{b: b, blockSize: b.BlockSize()}
+}
+
+func (x *ecb) BlockSize() int { return x.blockSize }
+
+type ecbEncrypter ecb
+
+// NewECBEncrypter returns a cipher.BlockMode that encrypts each blockSize
+// chunk of the input independently.
+func NewECBEncrypter(b cipher.Block) cipher.BlockMode {
+	return (*ecbEncrypter)(newECB(b))
+}
+
+func (x *ecbEncrypter) BlockSize() int { return x.blockSize }
+
+func (x *ecbEncrypter) CryptBlocks(dst, src []byte) {
+	if len(src)%x.blockSize != 0 {
+		panic("cipher: input not full blocks")
+	}
+	if len(dst) < len(src) {
+		panic("cipher: output smaller than input")
+	}
+	for len(src) > 0 {
+		x.b.Encrypt(dst, src[:x.blockSize])
+		src = src[x.blockSize:]
+		dst = dst[x.blockSize:]
+	}
+}
+
+type ecbDecrypter ecb
+
+// NewECBDecrypter returns a cipher.BlockMode that decrypts each blockSize
+// chunk of the input independently.
+func NewECBDecrypter(b cipher.Block) cipher.BlockMode {
+	return (*ecbDecrypter)(newECB(b))
+}
+
+func (x *ecbDecrypter) BlockSize() int { return x.blockSize }
+
+func (x *ecbDecrypter) CryptBlocks(dst, src []byte) {
+	if len(src)%x.blockSize != 0 {
+		panic("cipher: input not full blocks")
+	}
+	if len(dst) < len(src) {
+		panic("cipher: output smaller than input")
+	}
+	for len(src) > 0 {
+		x.b.Decrypt(dst, src[:x.blockSize])
+		src = src[x.blockSize:]
+		dst = dst[x.blockSize:]
+	}
+}
+
+// NewCBCEncrypter returns a cipher.BlockMode that CBC-encrypts using iv,
+// which must be b.BlockSize() bytes. This is a thin wrapper over the
+// stdlib crypto/cipher implementation so that ECB, CBC, CFB, OFB, and CTR
+// are all reachable from one place in this package.
+func NewCBCEncrypter(b cipher.Block, iv []byte) cipher.BlockMode {
+	return cipher.NewCBCEncrypter(b, iv)
+}
+
+// NewCBCDecrypter returns a cipher.BlockMode that CBC-decrypts using iv,
+// which must be b.BlockSize() bytes.
+func NewCBCDecrypter(b cipher.Block, iv []byte) cipher.BlockMode {
+	return cipher.NewCBCDecrypter(b, iv)
+}
+
+// Mode names one of the streaming block-cipher modes supported by
+// NewWriter/NewReader (CTR through NewCTRWriter/NewCTRReader).
+type Mode int
+
+const (
+	ECB Mode = iota
+	CBC
+	CFB
+	OFB
+	CTR
+)
+
+// streamOf adapts the stream-cipher modes (CFB, OFB, CTR) to a
+// cipher.Stream; ECB and CBC are handled separately since they are
+// block-aligned modes with pluggable padding rather than streams.
+func streamOf(mode Mode, b cipher.Block, iv []byte) cipher.Stream {
+	switch mode {
+	case CFB:
+		return cipher.NewCFBEncrypter(b, iv)
+	case OFB:
+		return cipher.NewOFB(b, iv)
+	case CTR:
+		return cipher.NewCTR(b, iv)
+	default:
+		panic("cipher: mode is not a stream mode")
+	}
+}
+
+func decryptStreamOf(mode Mode, b cipher.Block, iv []byte) cipher.Stream {
+	if mode == CFB {
+		return cipher.NewCFBDecrypter(b, iv)
+	}
+	return streamOf(mode, b, iv)
+}
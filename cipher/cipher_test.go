@@ -0,0 +1,158 @@
+package cipher
+
+import (
+	"bytes"
+	"crypto/aes"
+	"testing"
+)
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := []byte("YELLOW SUBMARINE")
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("could not create AES cipher: %v", err)
+	}
+
+	plainText := []byte("Now that the party is jumping, with the bass kicked in and the Vega's are pumpin'")
+
+	for _, tc := range []struct {
+		name    string
+		mode    Mode
+		padding Padding
+	}{
+		{"ECB/PKCS7", ECB, PKCS7{}},
+		{"CBC/PKCS7", CBC, PKCS7{}},
+		{"CBC/ISO10126", CBC, ISO10126{}},
+		{"CBC/ZeroPad", CBC, ZeroPad{}},
+		{"CFB", CFB, NoPadding{}},
+		{"OFB", OFB, NoPadding{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, block, tc.mode, tc.padding)
+			if err != nil {
+				t.Fatalf("could not create writer: %v", err)
+			}
+
+			if _, err := w.Write(plainText); err != nil {
+				t.Fatalf("could not write plaintext: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("could not close writer: %v", err)
+			}
+
+			r, err := NewReader(&buf, block, tc.mode, tc.padding)
+			if err != nil {
+				t.Fatalf("could not create reader: %v", err)
+			}
+
+			got := make([]byte, 1024)
+			n := 0
+			for {
+				m, err := r.Read(got[n:])
+				n += m
+				if err != nil {
+					break
+				}
+			}
+
+			if !bytes.Equal(got[:n], plainText) {
+				t.Fatalf("round trip mismatch: got %q, want %q", got[:n], plainText)
+			}
+		})
+	}
+}
+
+func TestCTRRoundTrip(t *testing.T) {
+	key := []byte("YELLOW SUBMARINE")
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("could not create AES cipher: %v", err)
+	}
+
+	iv := make([]byte, 16)
+	plainText := []byte("a CTR stream has no padding to worry about")
+
+	var buf bytes.Buffer
+	w := NewCTRWriter(&buf, block, iv)
+	if _, err := w.Write(plainText); err != nil {
+		t.Fatalf("could not write plaintext: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("could not close writer: %v", err)
+	}
+
+	r := NewCTRReader(&buf, block, iv)
+	got := make([]byte, len(plainText))
+	if _, err := r.Read(got); err != nil {
+		t.Fatalf("could not read plaintext: %v", err)
+	}
+
+	if !bytes.Equal(got, plainText) {
+		t.Fatalf("round trip mismatch: got %q, want %q", got, plainText)
+	}
+}
+
+func TestGCMSealOpen(t *testing.T) {
+	key := []byte("YELLOW SUBMARINE")
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("could not create AES cipher: %v", err)
+	}
+
+	aead, err := NewGCM(block)
+	if err != nil {
+		t.Fatalf("could not create GCM: %v", err)
+	}
+
+	plainText := []byte("an authenticated mode catches tampering")
+	sealed, err := aead.Seal(plainText, []byte("header"))
+	if err != nil {
+		t.Fatalf("could not seal: %v", err)
+	}
+
+	opened, err := aead.Open(sealed, []byte("header"))
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	if !bytes.Equal(opened, plainText) {
+		t.Fatalf("open mismatch: got %q, want %q", opened, plainText)
+	}
+
+	sealed[len(sealed)-1] ^= 0x01
+	if _, err := aead.Open(sealed, []byte("header")); err == nil {
+		t.Fatalf("expected tampered ciphertext to fail authentication")
+	}
+}
+
+func TestCCMSealOpen(t *testing.T) {
+	key := []byte("YELLOW SUBMARINE")
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("could not create AES cipher: %v", err)
+	}
+
+	aead, err := NewCCM(block)
+	if err != nil {
+		t.Fatalf("could not create CCM: %v", err)
+	}
+
+	plainText := []byte("CCM is CBC-MAC plus CTR, unlike GCM's GHASH plus CTR")
+	sealed, err := aead.Seal(plainText, []byte("header"))
+	if err != nil {
+		t.Fatalf("could not seal: %v", err)
+	}
+
+	opened, err := aead.Open(sealed, []byte("header"))
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	if !bytes.Equal(opened, plainText) {
+		t.Fatalf("open mismatch: got %q, want %q", opened, plainText)
+	}
+
+	sealed[len(sealed)-1] ^= 0x01
+	if _, err := aead.Open(sealed, []byte("header")); err == nil {
+		t.Fatalf("expected tampered ciphertext to fail authentication")
+	}
+}
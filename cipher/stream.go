@@ -0,0 +1,199 @@
+package cipher
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// Writer is an io.WriteCloser that encrypts everything written to it and
+// forwards the ciphertext to the underlying io.Writer. Close must be
+// called to flush the final, padded block; it is an error to write after
+// Close.
+type Writer struct {
+	w         io.Writer
+	block     cipher.Block
+	mode      Mode
+	padding   Padding
+	blockMode cipher.BlockMode // set for ECB/CBC
+	stream    cipher.Stream    // set for CFB/OFB/CTR
+	buf       []byte           // unwritten plaintext, block modes only
+	closed    bool
+}
+
+// NewWriter returns a Writer that encrypts plaintext with block under the
+// given mode. For CBC, CFB, and OFB a random IV is generated with
+// crypto/rand and written to w before any ciphertext, so NewReader on the
+// other end can recover it; ECB has no IV and CTR treats iv as its
+// initial counter value, which the caller must supply explicitly.
+//
+// For ECB and CBC, padding is applied automatically when Close is called.
+// CFB, OFB, and CTR are stream modes and padding is ignored for them; pass
+// NoPadding{} for clarity.
+func NewWriter(w io.Writer, block cipher.Block, mode Mode, padding Padding) (*Writer, error) {
+	bs := block.BlockSize()
+
+	switch mode {
+	case ECB:
+		return &Writer{w: w, block: block, mode: mode, padding: padding, blockMode: NewECBEncrypter(block)}, nil
+	case CBC, CFB, OFB:
+		iv := make([]byte, bs)
+		if _, err := rand.Read(iv); err != nil {
+			return nil, fmt.Errorf("could not generate IV: %w", err)
+		}
+		if _, err := w.Write(iv); err != nil {
+			return nil, fmt.Errorf("could not write IV: %w", err)
+		}
+
+		if mode == CBC {
+			return &Writer{w: w, block: block, mode: mode, padding: padding, blockMode: NewCBCEncrypter(block, iv)}, nil
+		}
+		return &Writer{w: w, block: block, mode: mode, padding: padding, stream: streamOf(mode, block, iv)}, nil
+	case CTR:
+		return nil, fmt.Errorf("CTR mode requires an explicit IV; use NewCTRWriter")
+	default:
+		return nil, fmt.Errorf("unknown mode %v", mode)
+	}
+}
+
+// NewCTRWriter is like NewWriter, but for CTR mode, where the caller
+// supplies (and is responsible for transmitting) the initial counter
+// value rather than having one generated automatically.
+func NewCTRWriter(w io.Writer, block cipher.Block, iv []byte) *Writer {
+	return &Writer{w: w, block: block, mode: CTR, padding: NoPadding{}, stream: streamOf(CTR, block, iv)}
+}
+
+func (e *Writer) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, fmt.Errorf("cipher: write after Close")
+	}
+
+	if e.stream != nil {
+		out := make([]byte, len(p))
+		e.stream.XORKeyStream(out, p)
+		return e.w.Write(out)
+	}
+
+	e.buf = append(e.buf, p...)
+	bs := e.blockMode.BlockSize()
+	n := (len(e.buf) / bs) * bs
+	if n == 0 {
+		return len(p), nil
+	}
+
+	out := make([]byte, n)
+	e.blockMode.CryptBlocks(out, e.buf[:n])
+	e.buf = e.buf[n:]
+	if _, err := e.w.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close pads and flushes any buffered plaintext. It is a no-op for stream
+// modes, which have nothing left to flush.
+func (e *Writer) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+
+	if e.blockMode == nil {
+		return nil
+	}
+
+	bs := e.blockMode.BlockSize()
+	padded := e.padding.Pad(e.buf, bs)
+	out := make([]byte, len(padded))
+	e.blockMode.CryptBlocks(out, padded)
+	_, err := e.w.Write(out)
+	return err
+}
+
+// Reader is an io.Reader that decrypts ciphertext read from the
+// underlying io.Reader, stripping padding from the final block once the
+// underlying reader is exhausted.
+type Reader struct {
+	r         io.Reader
+	block     cipher.Block
+	mode      Mode
+	padding   Padding
+	blockMode cipher.BlockMode
+	stream    cipher.Stream
+	pending   []byte // decrypted-but-unpadded output not yet returned
+	eof       bool
+}
+
+// NewReader returns a Reader that decrypts ciphertext produced by Writer.
+// For CBC, CFB, and OFB it first reads block.BlockSize() bytes from r as
+// the IV, mirroring NewWriter. CTR requires an explicit IV via
+// NewCTRReader, since NewWriter does not prepend one for CTR either.
+func NewReader(r io.Reader, block cipher.Block, mode Mode, padding Padding) (*Reader, error) {
+	bs := block.BlockSize()
+
+	switch mode {
+	case ECB:
+		return &Reader{r: r, block: block, mode: mode, padding: padding, blockMode: NewECBDecrypter(block)}, nil
+	case CBC, CFB, OFB:
+		iv := make([]byte, bs)
+		if _, err := io.ReadFull(r, iv); err != nil {
+			return nil, fmt.Errorf("could not read IV: %w", err)
+		}
+
+		if mode == CBC {
+			return &Reader{r: r, block: block, mode: mode, padding: padding, blockMode: NewCBCDecrypter(block, iv)}, nil
+		}
+		return &Reader{r: r, block: block, mode: mode, padding: padding, stream: decryptStreamOf(mode, block, iv)}, nil
+	case CTR:
+		return nil, fmt.Errorf("CTR mode requires an explicit IV; use NewCTRReader")
+	default:
+		return nil, fmt.Errorf("unknown mode %v", mode)
+	}
+}
+
+// NewCTRReader is the CTR-mode counterpart to NewCTRWriter.
+func NewCTRReader(r io.Reader, block cipher.Block, iv []byte) *Reader {
+	return &Reader{r: r, block: block, mode: CTR, padding: NoPadding{}, stream: decryptStreamOf(CTR, block, iv)}
+}
+
+func (d *Reader) Read(p []byte) (int, error) {
+	if d.stream != nil {
+		n, err := d.r.Read(p)
+		d.stream.XORKeyStream(p[:n], p[:n])
+		return n, err
+	}
+
+	// Block modes: buffer everything, since we can't unpad (and therefore
+	// can't know the true plaintext length) until we've seen the whole
+	// ciphertext.
+	if !d.eof {
+		bs := d.blockMode.BlockSize()
+		ct, err := io.ReadAll(d.r)
+		if err != nil {
+			return 0, err
+		}
+		d.eof = true
+
+		if len(ct) == 0 {
+			d.pending = nil
+		} else {
+			pt := make([]byte, len(ct))
+			d.blockMode.CryptBlocks(pt, ct)
+			unpadded, err := d.padding.Unpad(pt, bs)
+			if err != nil {
+				return 0, fmt.Errorf("could not unpad plaintext: %w", err)
+			}
+			d.pending = unpadded
+		}
+	}
+
+	if len(d.pending) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
@@ -1,10 +1,11 @@
 package cryptopals
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"math"
 	"math/big"
-	"strconv"
+	"sort"
 	"strings"
 )
 
@@ -190,70 +191,130 @@ func decryptRepeatingKeyXOR(cipherText []byte, key []byte) []byte {
 	return plainText
 }
 
-// This function returns the mean hamming distance between blocks of size
-// blockSize.
-func meanBlockHammingDistance(data []byte, blockSize int, opts ...map[string]string) (float64, error) {
-	// Get the average of maxBlocks blocks
-	maxBlocks := 10
-
-	if len(opts) > 0 {
-		intBlocks, err := strconv.ParseInt(opts[0]["maxBlocks"], 10, 16)
-		maxBlocks = int(intBlocks)
-		if err != nil {
-			return 0, fmt.Errorf("could not parse opts: %w", err)
-		}
+// KeysizeCandidate is one keysize's index-of-coincidence score from
+// DetectRepeatingKeySize, sorted best-first.
+type KeysizeCandidate struct {
+	Keysize int
+	IC      float64
+}
+
+// englishIC is the index of coincidence of English prose (and so, of a
+// column of repeating-key-XOR ciphertext recovered with the right keysize,
+// since XORing every byte in a column by the same key byte doesn't change
+// the *distribution* of byte values, just which symbol maps to which).
+// Random bytes land close to 1/256 per distinct value, giving IC ~= 0.0039
+// -- and a transposed column at the wrong keysize interleaves several
+// different key bytes' worth of English, which looks much more like random
+// bytes than a single-byte XOR of English prose does.
+const englishIC = 0.065
+
+// indexOfCoincidence computes IC = sum(f_c*(f_c-1)) / (N*(N-1)) over data's
+// byte-value frequencies, the probability that two bytes drawn at random
+// from data (without replacement) are equal.
+func indexOfCoincidence(data []byte) float64 {
+	n := len(data)
+	if n < 2 {
+		return 0
+	}
+
+	var freq [256]int
+	for _, b := range data {
+		freq[b]++
 	}
 
-	meanDistance := float64(0)
-	for i := 0; i < maxBlocks; i++ {
-		start := i * blockSize
-		first := data[start : start+blockSize]
-		second := data[start+blockSize : start+blockSize+blockSize]
-		distance, err := hamming(first, second)
+	sum := 0.0
+	for _, f := range freq {
+		sum += float64(f) * float64(f-1)
+	}
+
+	return sum / (float64(n) * float64(n-1))
+}
 
-		if err != nil {
-			return 0, fmt.Errorf("could not compute hamming distance: %w", err)
+// DetectRepeatingKeySize scores every candidate keysize in [min, max]
+// against englishIC: for each keysize k, it slices cipherText into k
+// columns (byte i of every k-byte block) and averages indexOfCoincidence
+// across the columns, since a column recovered with the true keysize is
+// just English prose run through a single-byte XOR. Candidates are
+// returned sorted by |IC - englishIC|, best guess first.
+func DetectRepeatingKeySize(cipherText []byte, minKeysize, maxKeysize int) []KeysizeCandidate {
+	candidates := make([]KeysizeCandidate, 0, maxKeysize-minKeysize+1)
+
+	for keysize := minKeysize; keysize <= maxKeysize; keysize++ {
+		if keysize <= 0 || keysize > len(cipherText) {
+			continue
 		}
 
-		normalizedDistance := float64(distance) / float64(blockSize)
-		meanDistance += normalizedDistance
-		meanDistance /= 2
+		columns := make([][]byte, keysize)
+		for i, b := range cipherText {
+			columns[i%keysize] = append(columns[i%keysize], b)
+		}
+
+		total := 0.0
+		for _, column := range columns {
+			total += indexOfCoincidence(column)
+		}
+
+		candidates = append(candidates, KeysizeCandidate{
+			Keysize: keysize,
+			IC:      total / float64(len(columns)),
+		})
 	}
 
-	return meanDistance, nil
+	sort.Slice(candidates, func(i, j int) bool {
+		return math.Abs(candidates[i].IC-englishIC) < math.Abs(candidates[j].IC-englishIC)
+	})
+
+	return candidates
 }
 
-// Returns the total hamming distance between each block and every other
-// block in data, given blockSize.
-func blockDistance(data []byte, blockSize int) (float64, error) {
-	numBlocks := len(data) / blockSize
+// DetectECBBlockSize scores every candidate block size in [min, max] by
+// index of coincidence, as DetectRepeatingKeySize scores keysizes, but
+// treating each whole blockSize-byte block as one symbol instead of
+// treating each byte position as its own column: ECB-encrypted data
+// repeats identical ciphertext blocks for identical plaintext blocks, so
+// the right block size is the one whose block-level IC is highest,
+// regardless of how it compares to englishIC (block values aren't English
+// text, so there's no single target IC to aim for here).
+func DetectECBBlockSize(cipherText []byte, minBlockSize, maxBlockSize int) []KeysizeCandidate {
+	candidates := make([]KeysizeCandidate, 0, maxBlockSize-minBlockSize+1)
+
+	for blockSize := minBlockSize; blockSize <= maxBlockSize; blockSize++ {
+		numBlocks := len(cipherText) / blockSize
+		if blockSize <= 0 || numBlocks < 2 {
+			continue
+		}
 
-	totalDistance := float64(0)
-	for i := 0; i < numBlocks; i++ {
-		for j := i; j < numBlocks; j++ {
-			if i == j {
-				continue
-			}
-			first := data[i*blockSize : (i+1)*blockSize]
-			second := data[j*blockSize : (j+1)*blockSize]
-			distance, err := hamming(first, second)
-			if err != nil {
-				return 0, fmt.Errorf("could not compute hamming distance: %w", err)
-			}
+		counts := make(map[string]int, numBlocks)
+		for i := 0; i < numBlocks; i++ {
+			block := cipherText[i*blockSize : (i+1)*blockSize]
+			counts[string(block)]++
+		}
 
-			totalDistance += math.Pow(float64(distance)/float64(blockSize), 2)
+		n := numBlocks
+		sum := 0.0
+		for _, f := range counts {
+			sum += float64(f) * float64(f-1)
 		}
+
+		candidates = append(candidates, KeysizeCandidate{
+			Keysize: blockSize,
+			IC:      sum / (float64(n) * float64(n-1)),
+		})
 	}
 
-	return math.Sqrt(totalDistance), nil
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].IC > candidates[j].IC
+	})
+
+	return candidates
 }
 
-// Returns the number of blocks that have a similarity score under minSimilarity. The score
-// is the hamming distance between the blocks.
-func numSimilarBlocks(data []byte, blockSize int, minSimilarity int) (int, error) {
+// Returns the total hamming distance between each block and every other
+// block in data, given blockSize.
+func blockDistance(data []byte, blockSize int) (float64, error) {
 	numBlocks := len(data) / blockSize
 
-	count := 0
+	totalDistance := float64(0)
 	for i := 0; i < numBlocks; i++ {
 		for j := i; j < numBlocks; j++ {
 			if i == j {
@@ -266,13 +327,11 @@ func numSimilarBlocks(data []byte, blockSize int, minSimilarity int) (int, error
 				return 0, fmt.Errorf("could not compute hamming distance: %w", err)
 			}
 
-			if distance <= minSimilarity {
-				count++
-			}
+			totalDistance += math.Pow(float64(distance)/float64(blockSize), 2)
 		}
 	}
 
-	return count, nil
+	return math.Sqrt(totalDistance), nil
 }
 
 func padPKCS7Bytes(plainText []byte, length int) ([]byte, error) {
@@ -355,67 +414,145 @@ func unpadPKCS7(data []byte) ([]byte, error) {
 	return data, nil
 }
 
-func padPKCS7(plainText string, length int) (string, error) {
-	padded, err := padPKCS7Bytes([]byte(plainText), length)
-	return string(padded), err
-}
+// unpadPKCS7ConstantTime is a timing-safe alternative to unpadPKCS7: it
+// always inspects all blockSize trailing bytes (no early exit once a
+// mismatch is found) and accumulates validity into a single mask rather
+// than returning as soon as something looks wrong, so the time taken
+// doesn't depend on which byte of the padding was wrong or how much of it
+// was wrong.
+func unpadPKCS7ConstantTime(data []byte, blockSize int) ([]byte, bool) {
+	if len(data) == 0 || len(data)%blockSize != 0 {
+		return nil, false
+	}
 
-func detectBlockSize(data []byte) (int, error) {
-	bestBlockSize := 0
+	n := len(data)
+	pad := data[n-1]
 
-	for i := 4; i <= 40; i++ {
-		distance, err := numSimilarBlocks(data, i, 4)
-		fmt.Println(i, distance)
-		if err != nil {
-			return 0, fmt.Errorf("could not calculate block distance: %w", err)
-		}
+	var bad byte
+	for i := 0; i < blockSize; i++ {
+		mismatch := data[n-1-i] ^ pad
+		shouldCheck := byte(subtle.ConstantTimeLessOrEq(i+1, int(pad)))
+		bad |= mismatch & (-shouldCheck)
+	}
+	bad |= 1 - byte(subtle.ConstantTimeLessOrEq(1, int(pad)))
+	bad |= 1 - byte(subtle.ConstantTimeLessOrEq(int(pad), blockSize))
 
-		// Pick the largest block size with similar blocks. This is due to aliasing
-		// effects of similarity. E.g., block size 16 with 1 similar block will have
-		// block size 8 with 2 similar blocks.
-		//
-		// Fixme: use square for similar block size
-		if distance > 0 {
-			bestBlockSize = i
-		}
+	if bad != 0 {
+		return data[:n], false
 	}
+	return data[:n-int(pad)], true
+}
 
-	return bestBlockSize, nil
+// Oracle is anything that can answer the Set 3 challenge 17 question --
+// "does this (iv, ciphertext) pair decrypt to valid PKCS7 padding?" -- for
+// PaddingOracleAttack, the same question crackCBCPaddingOracle already asks
+// of a bare func.
+type Oracle interface {
+	ValidPadding(iv, ciphertext []byte) bool
 }
 
-func parseCookie(cookie string) (map[string]string, error) {
-	parts := strings.Split(cookie, "&")
-	cookieMap := map[string]string{}
-	for _, part := range parts {
-		subParts := strings.Split(part, "=")
-		if len(subParts) != 2 {
-			return nil, fmt.Errorf("Invalid cookie part %s in %s", part, cookie)
-		}
+// PaddingOracleAttack is the caller-facing entry point to the same
+// byte-at-a-time attack as crackCBCPaddingOracle, generalized to a
+// caller-chosen block size and an Oracle implementation rather than a bare
+// func, for callers that aren't hardcoded to AES's 16-byte blocks.
+func PaddingOracleAttack(oracle Oracle, ciphertext, iv []byte, blockSize int) ([]byte, error) {
+	return crackCBCPaddingOracleBlockSize(oracle.ValidPadding, iv, ciphertext, blockSize)
+}
+
+// crackCBCPaddingOracle recovers the plaintext of ct (decrypted under iv)
+// one byte at a time using only oracle's yes/no answer to "does this
+// (iv, ciphertext) pair decrypt to valid PKCS7 padding?" -- the Set 3
+// challenge 17 attack. For each ciphertext block, it walks the padding
+// length from 1 to blockSize, XORing candidate bytes into the preceding
+// block until the oracle reports valid padding, which reveals one byte of
+// the CBC intermediate state (and hence one plaintext byte) per guess.
+func crackCBCPaddingOracle(oracle func(iv, ct []byte) bool, iv, ct []byte) ([]byte, error) {
+	return crackCBCPaddingOracleBlockSize(oracle, iv, ct, 16)
+}
 
-		cookieMap[strings.TrimSpace(subParts[0])] = subParts[1]
+// crackCBCPaddingOracleBlockSize is the block-size-parameterized core both
+// crackCBCPaddingOracle and PaddingOracleAttack share.
+func crackCBCPaddingOracleBlockSize(oracle func(iv, ct []byte) bool, iv, ct []byte, blockSize int) ([]byte, error) {
+	if len(ct) == 0 || len(ct)%blockSize != 0 {
+		return nil, fmt.Errorf("ciphertext must be a non-zero multiple of %d bytes", blockSize)
 	}
 
-	return cookieMap, nil
-}
+	blocks := [][]byte{iv}
+	for i := 0; i < len(ct); i += blockSize {
+		blocks = append(blocks, ct[i:i+blockSize])
+	}
+
+	var plainText []byte
+	for b := 1; b < len(blocks); b++ {
+		prev, cur := blocks[b-1], blocks[b]
+		intermediate := make([]byte, blockSize)
+		known := make([]byte, blockSize)
 
-func encodeCookie(cookie map[string]string, order []string) string {
-	cookies := []string{}
-	for _, k := range order {
-		cookies = append(cookies, fmt.Sprintf("%s=%s", sanitizeCookieValue(k), sanitizeCookieValue(cookie[k])))
+		for padVal := 1; padVal <= blockSize; padVal++ {
+			padIndex := blockSize - padVal
+			crafted := make([]byte, blockSize)
+			for i := padIndex + 1; i < blockSize; i++ {
+				crafted[i] = intermediate[i] ^ byte(padVal)
+			}
+
+			found := false
+			for guess := 0; guess < 256; guess++ {
+				crafted[padIndex] = byte(guess)
+				if !oracle(crafted, cur) {
+					continue
+				}
+
+				// When padVal is 1, a crafted block that merely
+				// reproduces the real penultimate byte also satisfies
+				// the oracle without telling us anything -- disambiguate
+				// by also flipping an earlier byte and re-checking.
+				if padVal == 1 && padIndex > 0 {
+					probe := append([]byte{}, crafted...)
+					probe[padIndex-1] ^= 0xFF
+					if !oracle(probe, cur) {
+						continue
+					}
+				}
+
+				intermediate[padIndex] = byte(guess) ^ byte(padVal)
+				known[padIndex] = intermediate[padIndex] ^ prev[padIndex]
+				found = true
+				break
+			}
+
+			if !found {
+				return nil, fmt.Errorf("could not recover byte at block %d, position %d", b, padIndex)
+			}
+		}
+
+		plainText = append(plainText, known...)
 	}
 
-	return strings.Join(cookies, "&")
+	return plainText, nil
 }
 
-func sanitizeCookieValue(val string) string {
-	sanitizedString := ""
-	for _, c := range val {
-		if c != '&' && c != '=' {
-			sanitizedString += string(c)
-		}
+// decryptAESCBCSafe is the "safe decrypt" companion to decryptAESCBC: it
+// unpads with unpadPKCS7ConstantTime and collapses every failure (bad
+// key, truncated ciphertext, bad padding) into the same generic error, so
+// it cannot be used as a padding oracle the way decryptAESCBC+unpadPKCS7
+// can.
+func decryptAESCBCSafe(cipherText, key, iv []byte) ([]byte, error) {
+	plainText, err := decryptAESCBC(cipherText, key, iv)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext")
 	}
 
-	return sanitizedString
+	unpadded, ok := unpadPKCS7ConstantTime(plainText, len(iv))
+	if !ok {
+		return nil, fmt.Errorf("invalid ciphertext")
+	}
+
+	return unpadded, nil
+}
+
+func padPKCS7(plainText string, length int) (string, error) {
+	padded, err := padPKCS7Bytes([]byte(plainText), length)
+	return string(padded), err
 }
 
 // Zero-pad hex strings to even-valued length
@@ -426,67 +563,95 @@ func zeroPad(s string) string {
 	return s
 }
 
-// Modular exponentiation for Big Ints
+// bigModExp computes base^exponent mod modulus. big.Int.Exp already picks
+// Montgomery multiplication for odd moduli internally, which is both faster
+// and allocates far less than the hand-rolled recursive square-and-multiply
+// this used to be, so there's no reason to keep reimplementing it here.
 func bigModExp(base *big.Int, exponent *big.Int, modulus *big.Int) *big.Int {
-	if modulus.Cmp(big.NewInt(1)) == 0 {
-		return big.NewInt(0)
-	}
-
-	if exponent.Cmp(big.NewInt(0)) == 0 {
-		return big.NewInt(1)
-	}
+	return new(big.Int).Exp(base, exponent, modulus)
+}
 
-	result := bigModExp(base, new(big.Int).Div(exponent, big.NewInt(2)), modulus)
-	result = new(big.Int).Mod(new(big.Int).Mul(result, result), modulus)
+// bigModExpCT computes base^exponent mod modulus via a Montgomery ladder,
+// for callers (RSA/DSA signing, DH private-key operations) where the
+// sequence of squarings and multiplications must not depend on the
+// exponent's bits. Unlike bigModExp/big.Int.Exp, which branch on each bit
+// to decide whether to multiply, this always computes R0*R1, R0^2, and R1^2
+// for every bit and selects which results land in which register by
+// multiplying with the bit and its complement instead of branching, so the
+// sequence of big.Int operations is the same regardless of exponent.
+func bigModExpCT(base *big.Int, exponent *big.Int, modulus *big.Int) *big.Int {
+	r0 := big.NewInt(1)
+	r1 := new(big.Int).Mod(base, modulus)
+
+	for i := exponent.BitLen() - 1; i >= 0; i-- {
+		bit := exponent.Bit(i)
+
+		product := new(big.Int).Mod(new(big.Int).Mul(r0, r1), modulus)
+		sq0 := new(big.Int).Mod(new(big.Int).Mul(r0, r0), modulus)
+		sq1 := new(big.Int).Mod(new(big.Int).Mul(r1, r1), modulus)
+
+		// Constant-index conditional swap: both branches are always
+		// computed above, and bit (0 or 1) just selects which square
+		// goes in which register via arithmetic rather than an if.
+		newR0 := new(big.Int).Mod(new(big.Int).Add(
+			new(big.Int).Mul(big.NewInt(int64(1-bit)), sq0),
+			new(big.Int).Mul(big.NewInt(int64(bit)), product),
+		), modulus)
+		newR1 := new(big.Int).Mod(new(big.Int).Add(
+			new(big.Int).Mul(big.NewInt(int64(bit)), sq1),
+			new(big.Int).Mul(big.NewInt(int64(1-bit)), product),
+		), modulus)
+
+		r0, r1 = newR0, newR1
+	}
+
+	return r0
+}
 
-	// if exponent & 1 != 0, means, if exponent % 2 != 0, means, if exponent is not divisible by 2
-	if new(big.Int).Mod(exponent, big.NewInt(2)).Int64() != 0 {
-		return new(big.Int).Mod(new(big.Int).Mul(new(big.Int).Mod(base, modulus), result), modulus)
+// IntNthRoot computes floor(x^(1/n)) via integer Newton iteration: seeded
+// at 1 << ceil(bitLen(x)/n), it repeats
+// x_{k+1} = ((n-1)*x_k + x/x_k^(n-1)) / n
+// until the iterate stops decreasing, which for this recurrence happens in
+// O(log log x) steps rather than cubeRoot's old O(log x) halving search,
+// then nudges down while the candidate overshoots x to land on the floor
+// exactly. remainder is x - root^n, and exact reports whether x is a
+// perfect nth power.
+func IntNthRoot(x *big.Int, n int) (root, remainder *big.Int, exact bool) {
+	if x.Sign() == 0 {
+		return big.NewInt(0), big.NewInt(0), true
 	}
 
-	return new(big.Int).Mod(result, modulus)
-}
+	nBig := big.NewInt(int64(n))
+	nMinus1 := big.NewInt(int64(n - 1))
 
-func cubeRoot(i *big.Int) (cbrt *big.Int, rem *big.Int) {
-	var (
-		n0    = big.NewInt(0)
-		n1    = big.NewInt(1)
-		n2    = big.NewInt(2)
-		n3    = big.NewInt(3)
-		guess = new(big.Int).Div(i, n2)
-		dx    = new(big.Int)
-		absDx = new(big.Int)
-		minDx = new(big.Int).Abs(i)
-		step  = new(big.Int).Abs(new(big.Int).Div(guess, n2))
-		cube  = new(big.Int)
-	)
+	shift := (x.BitLen() + n - 1) / n
+	guess := new(big.Int).Lsh(big.NewInt(1), uint(shift))
 
 	for {
-		cube.Exp(guess, n3, nil)
-		dx.Sub(i, cube)
-		cmp := dx.Cmp(n0)
-		if cmp == 0 {
-			return guess, n0
-		}
+		term := new(big.Int).Exp(guess, nMinus1, nil)
+		term.Div(x, term)
 
-		absDx.Abs(dx)
-		switch absDx.Cmp(minDx) {
-		case -1:
-			minDx.Set(absDx)
-		case 0:
-			return guess, dx
-		}
+		next := new(big.Int).Mul(guess, nMinus1)
+		next.Add(next, term)
+		next.Div(next, nBig)
 
-		switch cmp {
-		case -1:
-			guess.Sub(guess, step)
-		case +1:
-			guess.Add(guess, step)
+		if next.Cmp(guess) >= 0 {
+			break
 		}
+		guess = next
+	}
 
-		step.Div(step, n2)
-		if step.Cmp(n0) == 0 {
-			step.Set(n1)
-		}
+	pow := new(big.Int).Exp(guess, nBig, nil)
+	for pow.Cmp(x) > 0 {
+		guess.Sub(guess, big.NewInt(1))
+		pow.Exp(guess, nBig, nil)
 	}
+
+	return guess, new(big.Int).Sub(x, pow), pow.Cmp(x) == 0
+}
+
+// cubeRoot is the e=3 RSA broadcast attack's nth root: IntNthRoot(i, 3).
+func cubeRoot(i *big.Int) (cbrt *big.Int, rem *big.Int) {
+	root, remainder, _ := IntNthRoot(i, 3)
+	return root, remainder
 }
@@ -0,0 +1,188 @@
+package cryptopals
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//go:embed data/quadgrams.txt
+var quadgramData string
+
+// englishQuadgramCounts holds English quadgram frequencies parsed from
+// data/quadgrams.txt at startup, one "QQQQ count" pair per line. It's still
+// far short of the full ~389k-entry table from corpora like Google Books
+// n-grams, but a few hundred entries spanning common English word
+// fragments -- not just ones built around "the" -- is enough to dominate
+// scoring of any English sentence-length plaintext. Counts are relative,
+// not absolute; only their ratios matter.
+var englishQuadgramCounts = parseQuadgramCounts(quadgramData)
+
+// parseQuadgramCounts parses the "QQQQ count" lines embedded from
+// data/quadgrams.txt. It panics on a malformed line rather than returning
+// an error, the same way init() would for any other startup-only data this
+// package can't run without -- a broken data file is a build-time problem,
+// not a runtime one callers should have to check for.
+func parseQuadgramCounts(data string) map[string]int64 {
+	counts := make(map[string]int64)
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			panic(fmt.Sprintf("quadgrams: malformed line %q", line))
+		}
+		count, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			panic(fmt.Sprintf("quadgrams: malformed count in line %q: %v", line, err))
+		}
+		counts[fields[0]] = count
+	}
+	return counts
+}
+
+var (
+	quadgramTotal int64
+	quadgramFloor float64
+)
+
+func init() {
+	for _, count := range englishQuadgramCounts {
+		quadgramTotal += count
+	}
+	quadgramFloor = math.Log10(0.01 / float64(quadgramTotal))
+}
+
+// ScoreEnglishQuadgrams scores data as sum(log10(count[q]/total)) over every
+// overlapping 4-letter quadgram, using quadgramFloor (log10(0.01/total)) for
+// any 4-byte window that isn't four ASCII letters (case-folded to upper)
+// forming a known quadgram -- punctuation, digits, and control bytes all
+// fall back to the floor rather than being dropped, so every candidate
+// decryption of a given ciphertext is scored over the same number of
+// windows, len(data)-3, regardless of how many of its bytes happen to be
+// letters. Higher (less negative) scores mean more English-like text -- the
+// same convention calcStringScore uses, just with four-byte context instead
+// of one.
+func ScoreEnglishQuadgrams(data []byte) float64 {
+	if len(data) < 4 {
+		return quadgramFloor
+	}
+
+	upper := make([]byte, len(data))
+	score := float64(0)
+	for i, b := range data {
+		switch {
+		case b >= 'A' && b <= 'Z':
+			upper[i] = b
+		case b >= 'a' && b <= 'z':
+			upper[i] = b - 'a' + 'A'
+		default:
+			upper[i] = 0
+		}
+
+		// A byte outside printable ASCII (plus tab/newline/CR) can't appear
+		// in English prose at all, so it's a much stronger signal of a wrong
+		// key than an ordinary unseen quadgram -- without this, folding
+		// everything to uppercase for the table lookup makes the scorer
+		// blind to an XOR-by-0x20 key that only flips letter case, since
+		// that key scores identically to the real one once case is folded
+		// away. That key also turns some of that same 0x20 into a
+		// non-printable control byte wherever it lands on punctuation or
+		// whitespace, which this catches.
+		if b != '\t' && b != '\n' && b != '\r' && (b < 0x20 || b > 0x7e) {
+			score += 2 * quadgramFloor
+		}
+	}
+
+	for i := 0; i+4 <= len(upper); i++ {
+		window := upper[i : i+4]
+		if window[0] == 0 || window[1] == 0 || window[2] == 0 || window[3] == 0 {
+			score += quadgramFloor
+			continue
+		}
+		if count, ok := englishQuadgramCounts[string(window)]; ok {
+			score += math.Log10(float64(count) / float64(quadgramTotal))
+		} else {
+			score += quadgramFloor
+		}
+	}
+
+	return score
+}
+
+// crackXORByteQuadgrams is crackXORByteScore's single-byte-XOR cracker, but
+// judged by ScoreEnglishQuadgrams instead of single-character frequency, and
+// searching the full byte range rather than crackXORByteScore's printable-
+// ASCII subset -- quadgram context distinguishes real English from garbage
+// well enough that it doesn't need the candidate-key restriction.
+func crackXORByteQuadgrams(cipherText []byte) (key byte, score float64, plainText string) {
+	bestScore := math.Inf(-1)
+	var bestString string
+	var bestKey byte
+
+	for i := 0; i < 256; i++ {
+		candidateKey := byte(i)
+		candidatePlainText := decryptXORByte(cipherText, candidateKey)
+		candidateScore := ScoreEnglishQuadgrams(candidatePlainText)
+
+		if candidateScore > bestScore {
+			bestScore = candidateScore
+			bestString = string(candidatePlainText)
+			bestKey = candidateKey
+		}
+	}
+
+	return bestKey, bestScore, bestString
+}
+
+// crackRepeatingKeyXOR recovers a repeating-key XOR key from cipherText by
+// trying every keysize in [2, maxKeysize]: for each, it transposes
+// cipherText into keysize columns (each effectively single-byte XOR, since
+// every byte in a column was XORed with the same key byte) and cracks each
+// column independently by character frequency, the same way crackXORByte-
+// Score always has. What's new is how the keysize itself gets picked: each
+// keysize's reassembled candidate plaintext is scored whole, in its real
+// byte order, with ScoreEnglishQuadgrams, and the keysize with the best-
+// scoring plaintext wins. A column is too short and too scrambled (every
+// 4-byte window straddles keysize-apart plaintext bytes that were never
+// adjacent) for quadgram context to mean anything, but the reassembled
+// plaintext is real contiguous English, so this is judged against what the
+// plaintext actually reads like rather than a normalized-hamming-distance
+// heuristic, which frequently picks the wrong keysize.
+func crackRepeatingKeyXOR(cipherText []byte) (key []byte, plainText []byte, err error) {
+	maxKeysize := 40
+	if maxKeysize > len(cipherText)/2 {
+		maxKeysize = len(cipherText) / 2
+	}
+	if maxKeysize < 2 {
+		return nil, nil, fmt.Errorf("cipherText too short to guess a keysize")
+	}
+
+	bestScore := math.Inf(-1)
+	var bestKey []byte
+
+	for keysize := 2; keysize <= maxKeysize; keysize++ {
+		columns := make([][]byte, keysize)
+		for i, b := range cipherText {
+			columns[i%keysize] = append(columns[i%keysize], b)
+		}
+
+		candidateKey := make([]byte, keysize)
+		for i, column := range columns {
+			k, _, _ := crackXORByteScore(column)
+			candidateKey[i] = k
+		}
+
+		candidateScore := ScoreEnglishQuadgrams(decryptRepeatingKeyXOR(cipherText, candidateKey))
+		if candidateScore > bestScore {
+			bestScore = candidateScore
+			bestKey = candidateKey
+		}
+	}
+
+	return bestKey, decryptRepeatingKeyXOR(cipherText, bestKey), nil
+}
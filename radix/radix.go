@@ -0,0 +1,108 @@
+// Package radix provides arbitrary-base big-integer encodings (Base58,
+// Base62, or any caller-supplied alphabet) alongside the hex and base64
+// this module already leans on, for challenges that hand back ASN.1
+// integers, keys, or fingerprints in a base other than 16 or 64.
+package radix
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Base58Alphabet is the Bitcoin/IPFS alphabet: every digit and letter with
+// the visually ambiguous 0, O, I, and l removed.
+const Base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// Base62Alphabet is every digit and letter, case-sensitive, with no
+// characters excluded.
+const Base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// EncodeBaseN encodes src as a big-endian big.Int in the base given by
+// len(alphabet), using alphabet's characters as digits. Each leading
+// 0x00 byte in src becomes one leading alphabet[0] character in the
+// result, the standard way Base58 (and this encoding in general) keeps
+// leading zero bytes from being swallowed by the big.Int conversion.
+func EncodeBaseN(src []byte, alphabet string) string {
+	base := big.NewInt(int64(len(alphabet)))
+	zero := big.NewInt(0)
+
+	n := new(big.Int).SetBytes(src)
+
+	var digits []byte
+	mod := new(big.Int)
+	for n.Cmp(zero) > 0 {
+		n.DivMod(n, base, mod)
+		digits = append(digits, alphabet[mod.Int64()])
+	}
+
+	leadingZeros := 0
+	for _, b := range src {
+		if b != 0 {
+			break
+		}
+		leadingZeros++
+	}
+
+	out := make([]byte, leadingZeros, leadingZeros+len(digits))
+	for i := range out {
+		out[i] = alphabet[0]
+	}
+	for i := len(digits) - 1; i >= 0; i-- {
+		out = append(out, digits[i])
+	}
+
+	return string(out)
+}
+
+// DecodeBaseN reverses EncodeBaseN: each leading alphabet[0] character
+// becomes one leading 0x00 byte, and the remaining characters are folded
+// back into a big.Int via repeated multiply-add before being rendered as
+// big-endian bytes.
+func DecodeBaseN(s string, alphabet string) ([]byte, error) {
+	digitValue := make(map[byte]int64, len(alphabet))
+	for i := 0; i < len(alphabet); i++ {
+		digitValue[alphabet[i]] = int64(i)
+	}
+
+	base := big.NewInt(int64(len(alphabet)))
+	n := big.NewInt(0)
+	for i := 0; i < len(s); i++ {
+		v, ok := digitValue[s[i]]
+		if !ok {
+			return nil, fmt.Errorf("radix: byte %q at offset %d is not in the alphabet", s[i], i)
+		}
+		n.Mul(n, base)
+		n.Add(n, big.NewInt(v))
+	}
+
+	leadingZeros := 0
+	for i := 0; i < len(s) && s[i] == alphabet[0]; i++ {
+		leadingZeros++
+	}
+
+	decoded := n.Bytes()
+	out := make([]byte, leadingZeros+len(decoded))
+	copy(out[leadingZeros:], decoded)
+
+	return out, nil
+}
+
+// Base58Encode encodes src using the Bitcoin Base58Alphabet.
+func Base58Encode(src []byte) string {
+	return EncodeBaseN(src, Base58Alphabet)
+}
+
+// Base58Decode decodes a string produced by Base58Encode.
+func Base58Decode(s string) ([]byte, error) {
+	return DecodeBaseN(s, Base58Alphabet)
+}
+
+// Base62Encode encodes src using Base62Alphabet.
+func Base62Encode(src []byte) string {
+	return EncodeBaseN(src, Base62Alphabet)
+}
+
+// Base62Decode decodes a string produced by Base62Encode.
+func Base62Decode(s string) ([]byte, error) {
+	return DecodeBaseN(s, Base62Alphabet)
+}
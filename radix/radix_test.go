@@ -0,0 +1,77 @@
+package radix
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestBase58RoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  []byte
+	}{
+		{"empty", nil},
+		{"ascii", []byte("Hello, world!")},
+		{"leading zero byte", []byte{0x00, 0x01, 0x02, 0x03}},
+		{"all zero bytes", []byte{0x00, 0x00, 0x00}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded := Base58Encode(tc.src)
+			decoded, err := Base58Decode(encoded)
+			if err != nil {
+				t.Fatalf("could not decode: %v", err)
+			}
+			if !bytes.Equal(decoded, tc.src) {
+				t.Fatalf("round trip mismatch: got %x, want %x", decoded, tc.src)
+			}
+		})
+	}
+}
+
+func TestBase58KnownVector(t *testing.T) {
+	// https://en.bitcoin.it/wiki/Base58Check_encoding's plain-Base58 example.
+	got := Base58Encode([]byte("Hello World!"))
+	want := "2NEpo7TZRRrLZSi2U"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestBase62RoundTrip(t *testing.T) {
+	src := make([]byte, 64)
+	if _, err := rand.Read(src); err != nil {
+		t.Fatalf("could not generate random input: %v", err)
+	}
+
+	encoded := Base62Encode(src)
+	decoded, err := Base62Decode(encoded)
+	if err != nil {
+		t.Fatalf("could not decode: %v", err)
+	}
+	if !bytes.Equal(decoded, src) {
+		t.Fatalf("round trip mismatch: got %x, want %x", decoded, src)
+	}
+}
+
+func TestDecodeBaseNRejectsUnknownCharacter(t *testing.T) {
+	if _, err := Base58Decode("0OIl"); err == nil {
+		t.Fatalf("expected an error decoding characters excluded from the Base58 alphabet")
+	}
+}
+
+func TestEncodeBaseNCustomAlphabet(t *testing.T) {
+	binary := "01"
+	encoded := EncodeBaseN([]byte{0x0b}, binary)
+	if encoded != "1011" {
+		t.Fatalf("got %q, want %q", encoded, "1011")
+	}
+
+	decoded, err := DecodeBaseN(encoded, binary)
+	if err != nil {
+		t.Fatalf("could not decode: %v", err)
+	}
+	if !bytes.Equal(decoded, []byte{0x0b}) {
+		t.Fatalf("round trip mismatch: got %x, want %x", decoded, []byte{0x0b})
+	}
+}
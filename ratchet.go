@@ -0,0 +1,211 @@
+package cryptopals
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strconv"
+)
+
+// RPC is the message envelope the Ratchet exchanges, shaped like the RPC
+// type the S5C34 echo channel tests in set5_test.go declare locally: Code
+// names the message kind and Params carries its payload, hex-encoded the
+// same way every DH/AES field already is in those tests.
+type RPC struct {
+	Code   string
+	Params map[string]string
+}
+
+// ratchetKey is one point in a Ratchet's key history: either one of its own
+// DH keypairs (priv set) or one of the peer's public values (priv nil).
+type ratchetKey struct {
+	id   int
+	priv *big.Int
+	pub  *big.Int
+}
+
+// Ratchet drives a per-message Diffie-Hellman ratchet on top of the S5C34
+// echo channel the way OTR does: every outgoing message carries a fresh DH
+// public value g^x_i and the id of the most recent public value this side
+// has seen from the peer, and its key is H(peer_pub^x_i). Each side retains
+// only its own last two keypairs and the peer's last two public values --
+// enough for a message to still decrypt if it crosses the wire with the
+// ratchet step just before it -- so once a keypair ages out of that window
+// its private half is gone for good and nothing, including a compromise of
+// the current session key, can reach backward into traffic sealed under it.
+type Ratchet struct {
+	myKeys   []*ratchetKey // own keypairs, oldest first, at most 2 retained
+	peerKeys []*ratchetKey // peer public values, oldest first, at most 2 retained
+
+	nextOwnID int
+
+	msgKeyByOwnID map[int][]byte // most recent message key derived under each retained own keypair
+
+	// RevealedMACKeys accumulates the MAC key for every message key whose
+	// own keypair has aged out of myKeys, in the order they aged out --
+	// OTR-style proof of who authenticated a message, published only once
+	// the key can no longer be used to forge a new one.
+	RevealedMACKeys [][]byte
+}
+
+// NewRatchet generates the first of this side's DH keypairs.
+func NewRatchet() (*Ratchet, error) {
+	r := &Ratchet{msgKeyByOwnID: make(map[int][]byte)}
+	if err := r.rotate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// PublicValue returns this side's current (most recent) DH public value and
+// the id it was assigned, to hand to the peer's SetPeerPublicValue before
+// the first Send/Recv -- the same kind of out-of-band DH bootstrap
+// TestS5C34's genKeys already does before any encrypted traffic flows.
+func (r *Ratchet) PublicValue() (id int, pub *big.Int) {
+	k := r.myKeys[len(r.myKeys)-1]
+	return k.id, k.pub
+}
+
+// SetPeerPublicValue records the peer's initial DH public value under the
+// id the peer assigned it.
+func (r *Ratchet) SetPeerPublicValue(id int, pub *big.Int) {
+	r.rememberPeerKey(id, pub)
+}
+
+func (r *Ratchet) rotate() error {
+	x, err := akeRandExponent()
+	if err != nil {
+		return err
+	}
+
+	r.nextOwnID++
+	k := &ratchetKey{id: r.nextOwnID, priv: x, pub: bigModExp(akeG, x, akeP)}
+	r.myKeys = append(r.myKeys, k)
+
+	if len(r.myKeys) > 2 {
+		evicted := r.myKeys[0]
+		r.myKeys = r.myKeys[1:]
+		if mk, ok := r.msgKeyByOwnID[evicted.id]; ok {
+			r.RevealedMACKeys = append(r.RevealedMACKeys, macKeyFromEncKey(mk))
+			delete(r.msgKeyByOwnID, evicted.id)
+		}
+	}
+
+	return nil
+}
+
+func (r *Ratchet) rememberPeerKey(id int, pub *big.Int) {
+	for _, k := range r.peerKeys {
+		if k.id == id {
+			return
+		}
+	}
+
+	r.peerKeys = append(r.peerKeys, &ratchetKey{id: id, pub: pub})
+	if len(r.peerKeys) > 2 {
+		r.peerKeys = r.peerKeys[1:]
+	}
+}
+
+func (r *Ratchet) ownKey(id int) (*ratchetKey, bool) {
+	for _, k := range r.myKeys {
+		if k.id == id {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// ratchetMessageKey derives a message key from a DH shared secret the same
+// way ake.go derives its encryption/MAC keys from the AKE's shared secret:
+// a tagged SHA256 over the secret's bytes, truncated to an AES-128 key.
+func ratchetMessageKey(shared *big.Int) []byte {
+	return akeKDF(shared.Bytes(), 1)[:16]
+}
+
+// Send seals plaintext under a freshly rotated DH keypair combined with the
+// peer's most recently known public value, and returns the RPC to hand to
+// the peer's Recv.
+func (r *Ratchet) Send(plaintext []byte) (RPC, error) {
+	if len(r.peerKeys) == 0 {
+		return RPC{}, errors.New("ratchet: no peer public value yet; call SetPeerPublicValue first")
+	}
+	peer := r.peerKeys[len(r.peerKeys)-1]
+
+	if err := r.rotate(); err != nil {
+		return RPC{}, err
+	}
+	mine := r.myKeys[len(r.myKeys)-1]
+
+	shared := bigModExp(peer.pub, mine.priv, akeP)
+	key := ratchetMessageKey(shared)
+	r.msgKeyByOwnID[mine.id] = key
+
+	iv := make([]byte, 16)
+	if _, err := rand.Read(iv); err != nil {
+		return RPC{}, err
+	}
+	sealed, err := SealAESCBCHMAC(plaintext, key, iv)
+	if err != nil {
+		return RPC{}, err
+	}
+
+	return RPC{
+		Code: "Ratchet",
+		Params: map[string]string{
+			"pub":      mine.pub.Text(16),
+			"keyid":    strconv.Itoa(mine.id),
+			"ackkeyid": strconv.Itoa(peer.id),
+			"message":  hex.EncodeToString(sealed.Ciphertext),
+			"iv":       hex.EncodeToString(sealed.IV),
+			"mac":      hex.EncodeToString(sealed.MAC),
+		},
+	}, nil
+}
+
+// Recv opens a message produced by the peer's Send. It first records the
+// peer's public value carried in msg (ratcheting the peer side forward),
+// then derives the matching message key from whichever of this side's own
+// keypairs the message was sealed against -- which must still be within
+// the last two retained, or the message is unrecoverable by design.
+func (r *Ratchet) Recv(msg RPC) ([]byte, error) {
+	peerPub, ok := new(big.Int).SetString(msg.Params["pub"], 16)
+	if !ok {
+		return nil, errors.New("ratchet: malformed public value")
+	}
+	peerID, err := strconv.Atoi(msg.Params["keyid"])
+	if err != nil {
+		return nil, errors.New("ratchet: malformed keyid")
+	}
+	ackID, err := strconv.Atoi(msg.Params["ackkeyid"])
+	if err != nil {
+		return nil, errors.New("ratchet: malformed ackkeyid")
+	}
+
+	r.rememberPeerKey(peerID, peerPub)
+
+	mine, ok := r.ownKey(ackID)
+	if !ok {
+		return nil, errors.New("ratchet: message references a DH keypair that has already been ratcheted away")
+	}
+
+	shared := bigModExp(peerPub, mine.priv, akeP)
+	key := ratchetMessageKey(shared)
+	r.msgKeyByOwnID[mine.id] = key
+
+	ciphertext, err := hex.DecodeString(msg.Params["message"])
+	if err != nil {
+		return nil, err
+	}
+	iv, err := hex.DecodeString(msg.Params["iv"])
+	if err != nil {
+		return nil, err
+	}
+	mac, err := hex.DecodeString(msg.Params["mac"])
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenAESCBCHMAC(&EncryptedMessage{IV: iv, Ciphertext: ciphertext, MAC: mac}, key)
+}
@@ -0,0 +1,81 @@
+package cryptopals
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"errors"
+)
+
+// ErrAuthFailed is returned by OpenAESCBCHMAC for every authentication
+// failure -- bad MAC, bad key, bad padding, or a truncated message -- so a
+// network observer can't tell a tampered MAC from a padding problem the way
+// the TestS5C35 echo bot's "PADDING ERROR" replies let them.
+var ErrAuthFailed = errors.New("authentication failed")
+
+// EncryptedMessage is an AES-CBC ciphertext bundled with the IV it was
+// encrypted under and a MAC over both. SealAESCBCHMAC builds one in the
+// OTR-style encrypt-then-MAC order: pad and encrypt first, then tag
+// iv||ciphertext.
+type EncryptedMessage struct {
+	IV         []byte
+	Ciphertext []byte
+	MAC        []byte
+}
+
+// macKeyFromEncKey derives the MAC key from the encryption key the OTR way,
+// SHA1(encKey). Once a conversation is over, publishing encKey lets anyone
+// forge "authentic" messages under the same MAC key, so old transcripts
+// can't be used as proof of what either party actually said.
+func macKeyFromEncKey(encKey []byte) []byte {
+	h := sha1.Sum(encKey)
+	return h[:]
+}
+
+// SealAESCBCHMAC pads plain to a block boundary, encrypts it with AES-CBC
+// under encKey/iv, and tags iv||ciphertext with HMAC-SHA1 keyed by
+// macKeyFromEncKey(encKey).
+func SealAESCBCHMAC(plain, encKey, iv []byte) (*EncryptedMessage, error) {
+	padded, err := padPKCS7ToBlockSize(plain, len(iv))
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := encryptAESCBC(padded, encKey, iv)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha1.New, macKeyFromEncKey(encKey))
+	mac.Write(iv)
+	mac.Write(ciphertext)
+
+	return &EncryptedMessage{IV: iv, Ciphertext: ciphertext, MAC: mac.Sum(nil)}, nil
+}
+
+// OpenAESCBCHMAC verifies msg's MAC with a constant-time comparison before
+// ever touching PKCS7 padding, and collapses every failure into
+// ErrAuthFailed so the padding oracle from TestCBCPaddingOracleAttack has
+// nothing to observe.
+func OpenAESCBCHMAC(msg *EncryptedMessage, encKey []byte) ([]byte, error) {
+	mac := hmac.New(sha1.New, macKeyFromEncKey(encKey))
+	mac.Write(msg.IV)
+	mac.Write(msg.Ciphertext)
+	want := mac.Sum(nil)
+
+	if subtle.ConstantTimeCompare(want, msg.MAC) != 1 {
+		return nil, ErrAuthFailed
+	}
+
+	plain, err := decryptAESCBC(msg.Ciphertext, encKey, msg.IV)
+	if err != nil {
+		return nil, ErrAuthFailed
+	}
+
+	unpadded, ok := unpadPKCS7ConstantTime(plain, len(msg.IV))
+	if !ok {
+		return nil, ErrAuthFailed
+	}
+
+	return unpadded, nil
+}
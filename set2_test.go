@@ -10,6 +10,8 @@ This file consists of solutions to Set 2. Run with:
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
@@ -70,48 +72,306 @@ func TestECBEncryptDecrypt(t *testing.T) {
 	assertEquals(t, true, bytes.Equal(plainText, newPlainText))
 }
 
-func TestS2C11(t *testing.T) {
-	detectBlockSize := func(data []byte) (int, error) {
-		bestBlockSize := 0
-
-		for i := 4; i <= 40; i++ {
-			distance, err := numSimilarBlocks(data, i, 4)
-			fmt.Println(i, distance)
-			if err != nil {
-				return 0, fmt.Errorf("could not calculate block distance: %w", err)
-			}
+// TestEMEHidesRepeatedBlocks shows that, unlike plain ECB, repeated
+// plaintext blocks encrypted under EME do not produce repeated ciphertext
+// blocks, so detectAESECB never flags EME output.
+func TestEMEHidesRepeatedBlocks(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+	key := make([]byte, 16)
+	_, err := rand.Read(key)
+	assertNoError(t, err)
 
-			// Pick the largest block size with similar blocks. This is due to aliasing
-			// effects of similarity. E.g., block size 16 with 1 similar block will have
-			// block size 8 with 2 similar blocks.
-			//
-			// Fixme: use square for similar block size
-			if distance > 0 {
-				bestBlockSize = i
-			}
+	block := []byte("REPEATEDBLOCK!!!")
+	plainText := bytes.Repeat(block, 8)
+
+	cipherText, err := encryptAESEME(plainText, key, nil)
+	assertNoError(t, err)
+
+	seen := map[string]bool{}
+	for i := 0; i < len(cipherText); i += 16 {
+		assertFalse(t, seen[string(cipherText[i:i+16])])
+		seen[string(cipherText[i:i+16])] = true
+	}
+
+	isECB, _, err := detectAESECB(func(p []byte) ([]byte, error) {
+		padded, err := padPKCS7ToBlockSize(p, 16)
+		if err != nil {
+			return nil, err
 		}
+		return encryptAESEME(padded, key, nil)
+	})
+	assertNoError(t, err)
+	assertEquals(t, false, isECB)
 
-		return bestBlockSize, nil
+	decrypted, err := decryptAESEME(cipherText, key, nil)
+	assertNoError(t, err)
+	assertTrue(t, bytes.Equal(plainText, decrypted))
+}
+
+// TestEMEFilenameCipher demonstrates a filename cipher built on EME, which
+// is length-preserving over the PKCS7-padded name (the ciphertext is as
+// long as the padded name, not the raw one): since EME is a genuine
+// wide-block mode, changing a single
+// byte of the filename changes every byte of the ciphertext, and a
+// per-file tweak keeps two files with the same name (but different
+// tweaks) from ever sharing ciphertext.
+func TestEMEFilenameCipher(t *testing.T) {
+	key := []byte("YELLOW SUBMARINE")
+
+	encryptFilename := func(name string, tweak []byte) ([]byte, error) {
+		padded, err := padPKCS7ToBlockSize([]byte(name), 16)
+		if err != nil {
+			return nil, err
+		}
+		return encryptAESEME(padded, key, tweak)
 	}
 
+	decryptFilename := func(cipherText []byte, tweak []byte) (string, error) {
+		padded, err := decryptAESEME(cipherText, key, tweak)
+		if err != nil {
+			return "", err
+		}
+		plainText, err := unpadPKCS7(padded)
+		if err != nil {
+			return "", err
+		}
+		return string(plainText), nil
+	}
+
+	tweakA := bytes.Repeat([]byte{0xAA}, 16)
+	tweakB := bytes.Repeat([]byte{0xBB}, 16)
+
+	cipherA, err := encryptFilename("secret_plans.txt", tweakA)
+	assertNoError(t, err)
+	cipherB, err := encryptFilename("secret_plans.txt", tweakB)
+	assertNoError(t, err)
+	assertTrue(t, !bytes.Equal(cipherA, cipherB))
+
+	plainA, err := decryptFilename(cipherA, tweakA)
+	assertNoError(t, err)
+	assertEquals(t, "secret_plans.txt", plainA)
+}
+
+// TestEAXDetectsBitFlip shows why C16's CBC bit-flipping trick doesn't
+// work against an authenticated mode: flipping a single ciphertext bit,
+// which C16 uses to smuggle ";admin=true;" into an unauthenticated CBC
+// stream, is instead caught by EAX's tag.
+func TestEAXDetectsBitFlip(t *testing.T) {
+	key := []byte("YELLOW SUBMARINE")
+	nonce := []byte("UNIQUE PER MSG!!")
+	header := []byte("comment1=cooking%20MCs")
+	plainText := []byte("comment2=%20like%20a%20pound%20of%20bacon")
+
+	sealed, err := encryptAESEAX(key, nonce, header, plainText)
+	assertNoError(t, err)
+
+	decrypted, err := decryptAESEAX(key, nonce, header, sealed)
+	assertNoError(t, err)
+	assertTrue(t, bytes.Equal(plainText, decrypted))
+
+	sealed[0] ^= 0x80
+	_, err = decryptAESEAX(key, nonce, header, sealed)
+	assertHasError(t, err)
+}
+
+// TestSealAESCBCHMACRejectsTampering shows that OpenAESCBCHMAC returns the
+// same ErrAuthFailed whether a ciphertext bit got flipped (which would
+// otherwise surface as a padding error) or the MAC itself was corrupted, so
+// the echo protocol can no longer be used as the padding oracle demonstrated
+// in TestCBCPaddingOracleAttack.
+func TestSealAESCBCHMACRejectsTampering(t *testing.T) {
+	encKey := []byte("YELLOW SUBMARINE")
+	iv := make([]byte, 16)
+	_, err := rand.Read(iv)
+	assertNoError(t, err)
+
+	plainText := []byte("a message worth authenticating")
+	sealed, err := SealAESCBCHMAC(plainText, encKey, iv)
+	assertNoError(t, err)
+
+	opened, err := OpenAESCBCHMAC(sealed, encKey)
+	assertNoError(t, err)
+	assertTrue(t, bytes.Equal(plainText, opened))
+
+	flippedCiphertext := *sealed
+	flippedCiphertext.Ciphertext = append([]byte{}, sealed.Ciphertext...)
+	flippedCiphertext.Ciphertext[0] ^= 0x01
+	_, err = OpenAESCBCHMAC(&flippedCiphertext, encKey)
+	assertEquals(t, ErrAuthFailed, err)
+
+	flippedMAC := *sealed
+	flippedMAC.MAC = append([]byte{}, sealed.MAC...)
+	flippedMAC.MAC[0] ^= 0x01
+	_, err = OpenAESCBCHMAC(&flippedMAC, encKey)
+	assertEquals(t, ErrAuthFailed, err)
+}
+
+// TestCBCPaddingOracleAttack shows that crackCBCPaddingOracle recovers
+// plaintext through an oracle that only answers "is this valid PKCS7
+// padding", and that wrapping decryption in a MAC-then-check flow (verify an
+// HMAC over iv||ciphertext before ever touching padding) denies the
+// attacker any signal to work with, even though decryptAESCBCSafe still
+// uses the timing-safe unpad underneath.
+func TestCBCPaddingOracleAttack(t *testing.T) {
 	rand.Seed(time.Now().UnixNano())
-	plainText, err := ioutil.ReadFile("data/11.txt")
+	key := []byte("YELLOW SUBMARINE")
+	iv := make([]byte, 16)
+	_, err := rand.Read(iv)
 	assertNoError(t, err)
 
-	cipherText, err := encryptAESRandom(plainText)
+	plainText := []byte("Set 3's padding oracle leaks one byte per guess via valid/invalid")
+	padded, err := padPKCS7ToBlockSize(plainText, 16)
+	assertNoError(t, err)
+	cipherText, err := encryptAESCBC(padded, key, iv)
 	assertNoError(t, err)
 
-	similarity, err := numSimilarBlocks(cipherText, 16, 0)
+	vulnerableOracle := func(iv, ct []byte) bool {
+		plainText, err := decryptAESCBC(ct, key, iv)
+		if err != nil {
+			return false
+		}
+		// unpadPKCS7's backward scan isn't bounded to the claimed pad length,
+		// so it can spuriously reject valid padding when an earlier plaintext
+		// byte happens to equal the padding byte -- exactly the kind of false
+		// negative crackCBCPaddingOracle's byte-at-a-time guessing runs into
+		// and can't recover from. unpadPKCS7ConstantTime only ever inspects
+		// the trailing blockSize bytes, matching real PKCS7 validation.
+		_, ok := unpadPKCS7ConstantTime(plainText, 16)
+		return ok
+	}
+
+	cracked, err := crackCBCPaddingOracle(vulnerableOracle, iv, cipherText)
 	assertNoError(t, err)
+	assertTrue(t, bytes.HasPrefix(cracked, plainText))
+
+	macKey := []byte("a separate MAC key, not the AES key")
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(iv)
+	mac.Write(cipherText)
+	validTag := mac.Sum(nil)
+
+	safeOracle := func(iv, ct []byte) bool {
+		m := hmac.New(sha256.New, macKey)
+		m.Write(iv)
+		m.Write(ct)
+		if !hmac.Equal(m.Sum(nil), validTag) {
+			return false
+		}
+
+		_, err := decryptAESCBCSafe(ct, key, iv)
+		return err == nil
+	}
 
-	detectedBlockSize, err := detectBlockSize(cipherText)
+	_, err = crackCBCPaddingOracle(safeOracle, iv, cipherText)
+	assertHasError(t, err)
+}
+
+// vulnerablePaddingOracle adapts a vulnerable oracle func to the Oracle
+// interface PaddingOracleAttack takes.
+type vulnerablePaddingOracle struct {
+	key []byte
+}
+
+func (o vulnerablePaddingOracle) ValidPadding(iv, ciphertext []byte) bool {
+	plainText, err := decryptAESCBC(ciphertext, o.key, iv)
+	if err != nil {
+		return false
+	}
+	// See the comment on vulnerableOracle in TestCBCPaddingOracleAttack:
+	// unpadPKCS7's unbounded backward scan produces false negatives that
+	// make PaddingOracleAttack flaky, so the oracle validates with the
+	// bounded unpadPKCS7ConstantTime check instead.
+	_, ok := unpadPKCS7ConstantTime(plainText, 16)
+	return ok
+}
+
+// TestPaddingOracleAttack shows PaddingOracleAttack, the exported
+// Oracle-based entry point to the same attack TestCBCPaddingOracleAttack
+// exercises through a bare func, recovering the same plaintext.
+func TestPaddingOracleAttack(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+	key := []byte("YELLOW SUBMARINE")
+	iv := make([]byte, 16)
+	_, err := rand.Read(iv)
+	assertNoError(t, err)
+
+	plainText := []byte("Set 3's padding oracle leaks one byte per guess via valid/invalid")
+	padded, err := padPKCS7ToBlockSize(plainText, 16)
 	assertNoError(t, err)
+	cipherText, err := encryptAESCBC(padded, key, iv)
+	assertNoError(t, err)
+
+	cracked, err := PaddingOracleAttack(vulnerablePaddingOracle{key: key}, cipherText, iv, 16)
+	assertNoError(t, err)
+	assertTrue(t, bytes.HasPrefix(cracked, plainText))
+}
 
-	// If there are any similar blocks, then this is ECB
-	if similarity > 0 {
-		fmt.Println(similarity, "ECB", detectedBlockSize)
-	} else {
-		fmt.Println(similarity, "CBC", detectedBlockSize)
+// BenchmarkEncryptAESECB and BenchmarkDecryptAESECB measure the
+// throughput of the batched CryptBlocks-based ECB path (via
+// cryptopals/cipher) that replaced the old per-block start/end slicing
+// loop.
+func BenchmarkEncryptAESECB(b *testing.B) {
+	key := make([]byte, 16)
+	_, err := rand.Read(key)
+	if err != nil {
+		b.Fatalf("could not generate key: %v", err)
+	}
+
+	plainText := make([]byte, 16*1024)
+	_, err = rand.Read(plainText)
+	if err != nil {
+		b.Fatalf("could not generate plaintext: %v", err)
+	}
+
+	b.SetBytes(int64(len(plainText)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := encryptAESECB(plainText, key, 16); err != nil {
+			b.Fatalf("could not encrypt: %v", err)
+		}
+	}
+}
+
+func BenchmarkDecryptAESECB(b *testing.B) {
+	key := make([]byte, 16)
+	_, err := rand.Read(key)
+	if err != nil {
+		b.Fatalf("could not generate key: %v", err)
+	}
+
+	plainText := make([]byte, 16*1024)
+	_, err = rand.Read(plainText)
+	if err != nil {
+		b.Fatalf("could not generate plaintext: %v", err)
+	}
+
+	cipherText, err := encryptAESECB(plainText, key, 16)
+	if err != nil {
+		b.Fatalf("could not encrypt: %v", err)
+	}
+
+	b.SetBytes(int64(len(cipherText)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := decryptAESECB(cipherText, key, 16); err != nil {
+			b.Fatalf("could not decrypt: %v", err)
+		}
+	}
+}
+
+func TestS2C11(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+	plainText, err := ioutil.ReadFile("data/11.txt")
+	assertNoError(t, err)
+
+	// Run many trials: encryptAESRandom now reports the mode it actually
+	// used (rc.Mode), so the test can assert DetectMode's accuracy
+	// directly instead of eyeballing fmt.Println output.
+	for i := 0; i < 20; i++ {
+		rc, err := encryptAESRandom(plainText)
+		assertNoError(t, err)
+
+		assertEquals(t, rc.Mode, DetectMode(rc.Ciphertext, 16))
 	}
 }
 
@@ -486,3 +746,74 @@ func TestS2C16(t *testing.T) {
 	// Verify that we now have ";admin=true" in our cipherText
 	assertTrue(t, isCracked(cipherText))
 }
+
+// prosePlainText is ordinary English prose, dense in the common quadgrams
+// (NTHE, THAT, WITH, THER...) that give ScoreEnglishQuadgrams its
+// signal -- unlike a pangram, which is built to avoid repeating words and
+// so starves a small quadgram table of the repetition it needs.
+const prosePlainText = "When this text repeats common words enough times, " +
+	"the quadgram scorer has plenty of signal to identify the correct key " +
+	"and the correct keysize that was used to encrypt this message in the " +
+	"first place, the same way it would with any other English text."
+
+// TestCrackXORByteQuadgrams shows quadgram scoring picking the right
+// single-byte XOR key from a sentence-length ciphertext.
+func TestCrackXORByteQuadgrams(t *testing.T) {
+	plainText := []byte(prosePlainText)
+	key := byte(42)
+	cipherText := decryptXORByte(plainText, key)
+
+	gotKey, _, gotPlainText := crackXORByteQuadgrams(cipherText)
+	assertEquals(t, key, gotKey)
+	assertEquals(t, string(plainText), gotPlainText)
+}
+
+// TestCrackRepeatingKeyXOR shows crackRepeatingKeyXOR recovering both the
+// keysize and the key itself, using quadgram-scored plaintext to choose
+// between candidate keysizes rather than a normalized-hamming-distance
+// heuristic.
+func TestCrackRepeatingKeyXOR(t *testing.T) {
+	plainText := []byte(strings.Repeat(prosePlainText+" ", 6))
+	key := []byte("LEMON")
+
+	cipherText := decryptRepeatingKeyXOR(plainText, key)
+
+	gotKey, gotPlainText, err := crackRepeatingKeyXOR(cipherText)
+	assertNoError(t, err)
+	assertEquals(t, len(key), len(gotKey))
+	assertTrue(t, bytes.Equal(plainText, gotPlainText))
+}
+
+// TestDetectRepeatingKeySize shows the true keysize scoring closest to
+// englishIC among a range of candidates that includes several multiples
+// and near-misses of it.
+func TestDetectRepeatingKeySize(t *testing.T) {
+	plainText := []byte(strings.Repeat(prosePlainText+" ", 10))
+	key := []byte("LEMON")
+
+	cipherText := decryptRepeatingKeyXOR(plainText, key)
+
+	// Every multiple of the true keysize also yields columns that are
+	// pure single-byte XOR and so scores just as well by IC -- keep the
+	// range below the first multiple (10) so there's one unambiguous
+	// best answer.
+	candidates := DetectRepeatingKeySize(cipherText, 2, 9)
+	assertTrue(t, len(candidates) > 0)
+	assertEquals(t, len(key), candidates[0].Keysize)
+}
+
+// TestDetectECBBlockSize shows the true block size scoring highest once
+// ECB repeats identical ciphertext blocks for a repeated plaintext block:
+// only at size 16 is every block identical, while other candidate sizes
+// (8, 10, 20, ...) see some repeats but far fewer.
+func TestDetectECBBlockSize(t *testing.T) {
+	key := []byte("YELLOW SUBMARINE")
+	plainText := bytes.Repeat([]byte("AAAAAAAAAAAAAAAA"), 8)
+
+	cipherText, err := encryptAESECB(plainText, key, 16)
+	assertNoError(t, err)
+
+	candidates := DetectECBBlockSize(cipherText, 5, 20)
+	assertTrue(t, len(candidates) > 0)
+	assertEquals(t, 16, candidates[0].Keysize)
+}
@@ -2,7 +2,10 @@ package cryptopals
 
 import (
 	"bytes"
+	"crypto/dsa"
+	crand "crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"math"
@@ -12,24 +15,32 @@ import (
 	"time"
 )
 
-func bigModExp(base *big.Int, exponent *big.Int, modulus *big.Int) *big.Int {
-	if modulus.Cmp(big.NewInt(1)) == 0 {
-		return big.NewInt(0)
+// paramsFromSealedMessage and sealedMessageFromParams carry an
+// EncryptedMessage over the RPC Params maps TestS5C34 and TestS5C35 already
+// use for everything else, hex-encoding each field the same way those maps
+// hex-encode big.Int values elsewhere in this file.
+func paramsFromSealedMessage(msg *EncryptedMessage) map[string]string {
+	return map[string]string{
+		"message": hex.EncodeToString(msg.Ciphertext),
+		"iv":      hex.EncodeToString(msg.IV),
+		"mac":     hex.EncodeToString(msg.MAC),
 	}
+}
 
-	if exponent.Cmp(big.NewInt(0)) == 0 {
-		return big.NewInt(1)
+func sealedMessageFromParams(params map[string]string) (*EncryptedMessage, error) {
+	ciphertext, err := hex.DecodeString(params["message"])
+	if err != nil {
+		return nil, err
 	}
-
-	result := bigModExp(base, new(big.Int).Div(exponent, big.NewInt(2)), modulus)
-	result = new(big.Int).Mod(new(big.Int).Mul(result, result), modulus)
-
-	// if exponent & 1 != 0, means, if exponent % 2 != 0, means, if exponent is not divisible by 2
-	if new(big.Int).Mod(exponent, big.NewInt(2)).Int64() != 0 {
-		return new(big.Int).Mod(new(big.Int).Mul(new(big.Int).Mod(base, modulus), result), modulus)
+	iv, err := hex.DecodeString(params["iv"])
+	if err != nil {
+		return nil, err
 	}
-
-	return new(big.Int).Mod(result, modulus)
+	mac, err := hex.DecodeString(params["mac"])
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedMessage{IV: iv, Ciphertext: ciphertext, MAC: mac}, nil
 }
 
 func TestS5C33(t *testing.T) {
@@ -147,40 +158,23 @@ func TestS5C34(t *testing.T) {
 					// Echo message. Validate that we have keys.
 					assertTrue(t, authenticated)
 
-					// Decode encrypted message and IV
-					cipherTextHex, ok := msg.Params["message"]
-					assertTrue(t, ok)
-
-					cipherText, err := hex.DecodeString(cipherTextHex)
-					assertNoError(t, err)
-
-					ivHex, ok := msg.Params["iv"]
-					assertTrue(t, ok)
-
-					iv, err := hex.DecodeString(ivHex)
+					sealed, err := sealedMessageFromParams(msg.Params)
 					assertNoError(t, err)
-					assertEquals(t, 16, len(iv))
 
-					// Decrypt message
-					message, err := decryptAESCBC(cipherText, key, iv)
-					assertNoError(t, err)
-					unpaddedMessage, err := unpadPKCS7(message)
+					unpaddedMessage, err := OpenAESCBCHMAC(sealed, key)
 					assertNoError(t, err)
 					fmt.Println("echoBot (recv:decrypted):", string(unpaddedMessage))
 
-					// Encrypt with new IV and return to client
+					iv := make([]byte, 16)
 					_, err = rand.Read(iv)
 					assertNoError(t, err)
 
-					cipherText, err = encryptAESCBC(message, key, iv)
+					reply, err := SealAESCBCHMAC(unpaddedMessage, key, iv)
 					assertNoError(t, err)
 
 					c <- RPC{
-						Code: "ECHOReply",
-						Params: map[string]string{
-							"message": hex.EncodeToString(cipherText),
-							"iv":      hex.EncodeToString(iv),
-						},
+						Code:   "ECHOReply",
+						Params: paramsFromSealedMessage(reply),
 					}
 				}
 			}
@@ -232,24 +226,10 @@ func TestS5C34(t *testing.T) {
 					// Echo message. Validate that we have keys.
 					assertTrue(t, authenticated)
 
-					// Decode encrypted message and IV
-					cipherTextHex, ok := msg.Params["message"]
-					assertTrue(t, ok)
-
-					cipherText, err := hex.DecodeString(cipherTextHex)
-					assertNoError(t, err)
-
-					ivHex, ok := msg.Params["iv"]
-					assertTrue(t, ok)
-
-					iv, err := hex.DecodeString(ivHex)
+					sealed, err := sealedMessageFromParams(msg.Params)
 					assertNoError(t, err)
-					assertEquals(t, 16, len(iv))
 
-					// Decrypt message
-					message, err := decryptAESCBC(cipherText, key, iv)
-					assertNoError(t, err)
-					unpaddedMessage, err := unpadPKCS7(message)
+					unpaddedMessage, err := OpenAESCBCHMAC(sealed, key)
 					assertNoError(t, err)
 					fmt.Println("middleMan (decrypted:client):", string(unpaddedMessage))
 					assertTrue(t, bytes.Equal(plainText, unpaddedMessage))
@@ -260,27 +240,13 @@ func TestS5C34(t *testing.T) {
 					// Decrypt echoServer reply
 					reply := <-echoChan
 
-					// Decode encrypted message and IV
-					cipherTextHex, ok = reply.Params["message"]
-					assertTrue(t, ok)
-
-					cipherText, err = hex.DecodeString(cipherTextHex)
+					repliedSealed, err := sealedMessageFromParams(reply.Params)
 					assertNoError(t, err)
 
-					ivHex, ok = reply.Params["iv"]
-					assertTrue(t, ok)
-
-					iv, err = hex.DecodeString(ivHex)
-					assertNoError(t, err)
-					assertEquals(t, 16, len(iv))
-
-					// Decrypt message
-					message, err = decryptAESCBC(cipherText, key, iv)
+					unpaddedReply, err := OpenAESCBCHMAC(repliedSealed, key)
 					assertNoError(t, err)
-					unpaddedMessage, err = unpadPKCS7(message)
-					assertNoError(t, err)
-					fmt.Println("middleMan (decrypted:echoBot):", string(unpaddedMessage))
-					assertTrue(t, bytes.Equal(plainText, unpaddedMessage))
+					fmt.Println("middleMan (decrypted:echoBot):", string(unpaddedReply))
+					assertTrue(t, bytes.Equal(plainText, unpaddedReply))
 
 					c <- reply
 				}
@@ -321,44 +287,26 @@ func TestS5C34(t *testing.T) {
 		key := md[:16]
 		fmt.Println("aliceBot key:", hex.EncodeToString(key))
 
-		// Pad message and send to Echo Server
-		message, err := padPKCS7ToBlockSize(plainText, 16)
-		assertNoError(t, err)
+		// Send message to Echo Server
 		iv := make([]byte, 16)
-		_, err = rand.Read(iv)
+		_, err := rand.Read(iv)
 		assertNoError(t, err)
 
-		cipherText, err := encryptAESCBC(message, key, iv)
+		sealed, err := SealAESCBCHMAC(plainText, key, iv)
 		assertNoError(t, err)
 
 		echoChan <- RPC{
-			Code: "ECHO",
-			Params: map[string]string{
-				"message": hex.EncodeToString(cipherText),
-				"iv":      hex.EncodeToString(iv),
-			},
+			Code:   "ECHO",
+			Params: paramsFromSealedMessage(sealed),
 		}
 
 		reply = <-echoChan
 		assertEquals(t, reply.Code, "ECHOReply")
 
-		// Decode encrypted reply and IV
-		cipherTextHex, ok := reply.Params["message"]
-		assertTrue(t, ok)
-
-		cipherText, err = hex.DecodeString(cipherTextHex)
+		repliedSealed, err := sealedMessageFromParams(reply.Params)
 		assertNoError(t, err)
 
-		ivHex, ok := reply.Params["iv"]
-		assertTrue(t, ok)
-
-		iv, err = hex.DecodeString(ivHex)
-		assertNoError(t, err)
-		assertEquals(t, 16, len(iv))
-
-		message, err = decryptAESCBC(cipherText, key, iv)
-		assertNoError(t, err)
-		unpaddedMessage, err := unpadPKCS7(message)
+		unpaddedMessage, err := OpenAESCBCHMAC(repliedSealed, key)
 		assertNoError(t, err)
 
 		fmt.Println("aliceBot (recv:decrypted):", string(unpaddedMessage))
@@ -377,6 +325,249 @@ func TestS5C34(t *testing.T) {
 	aliceBot(mmChan)
 }
 
+// TestS5C34SMP shows that the DH parameter-injection MITM above isn't the
+// only threat an echo protocol needs to worry about: even once both sides
+// share a session key, SMP lets them confirm out-of-band that they're
+// talking to who they think they are, by running the four-message exchange
+// over the key and checking whether it converges on the same secret. It
+// doesn't relay the SMP messages through an actual man in the middle --
+// instead it drives Alice against a peer that holds a different secret,
+// which is what SMP sees whenever the party on the other end isn't the real
+// Bob: an attacker who's taken over the session but never learned his
+// passphrase looks identical to runSMP as a mismatched secretB.
+func TestS5C34SMP(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	pStr := "ffffffffffffffffc90fdaa22168c234c4c6628b80dc1cd129024e088a67cc74020bbea63b139b22514a08798e3404ddef9519b3cd3a431b302b0a6df25f14374fe1356d6d51c245e485b576625e7ec6f44c42e9a637ed6b0bff5cb6f406b7edee386bfb5a899fa5ae9f24117c4b1fe649286651ece45b3dc2007cb8a163bf0598da48361c55d39a69163fa8fd24cf5f83655d23dca3ad961c62f356208552bb9ed529077096966d670c354e4abc9804f1746c08ca237327ffffffffffffffff"
+	p, ok := new(big.Int).SetString(pStr, 16)
+	assertTrue(t, ok)
+
+	type RPC struct {
+		Code   string
+		Params map[string]string
+	}
+
+	// runSMP drives the four-message SMP exchange over a channel, as Alice
+	// and Bob would once they already share a DH session key, and reports
+	// whether both sides agreed the secrets matched.
+	runSMP := func(secretA, secretB string) bool {
+		sessionKey := []byte("shared-dh-session-key")
+		alice := NewSMP(p, sessionKey, []byte(secretA))
+		bob := NewSMP(p, sessionKey, []byte(secretB))
+
+		c := make(chan RPC)
+		bobMatched := make(chan bool, 1)
+
+		go func() {
+			msg1 := <-c
+			b1, err := hex.DecodeString(msg1.Params["msg"])
+			assertNoError(t, err)
+			msg2, err := bob.Step2(b1)
+			assertNoError(t, err)
+			c <- RPC{Code: "SMP2", Params: map[string]string{"msg": hex.EncodeToString(msg2)}}
+
+			msg3 := <-c
+			b3, err := hex.DecodeString(msg3.Params["msg"])
+			assertNoError(t, err)
+			msg4, matched, err := bob.Step4(b3)
+			assertNoError(t, err)
+			bobMatched <- matched
+			c <- RPC{Code: "SMP4", Params: map[string]string{"msg": hex.EncodeToString(msg4)}}
+		}()
+
+		msg1, err := alice.Step1()
+		assertNoError(t, err)
+		c <- RPC{Code: "SMP1", Params: map[string]string{"msg": hex.EncodeToString(msg1)}}
+
+		reply := <-c
+		b2, err := hex.DecodeString(reply.Params["msg"])
+		assertNoError(t, err)
+		msg3, err := alice.Step3(b2)
+		assertNoError(t, err)
+		c <- RPC{Code: "SMP3", Params: map[string]string{"msg": hex.EncodeToString(msg3)}}
+
+		reply = <-c
+		b4, err := hex.DecodeString(reply.Params["msg"])
+		assertNoError(t, err)
+		aliceMatched, err := alice.Verify(b4)
+		assertNoError(t, err)
+
+		assertEquals(t, aliceMatched, <-bobMatched)
+		return aliceMatched
+	}
+
+	// Alice and the real Bob share the secret: SMP confirms the session.
+	assertTrue(t, runSMP("correcthorsebatterystaple", "correcthorsebatterystaple"))
+
+	// Someone impersonating Bob over the same session key, but who never
+	// learned the real passphrase, fails SMP authentication.
+	assertFalse(t, runSMP("correcthorsebatterystaple", "a wild guess"))
+}
+
+func genDSAKey(t *testing.T) *dsa.PrivateKey {
+	var params dsa.Parameters
+	assertNoError(t, dsa.GenerateParameters(&params, crand.Reader, dsa.L1024N160))
+
+	priv := &dsa.PrivateKey{PublicKey: dsa.PublicKey{Parameters: params}}
+	assertNoError(t, dsa.GenerateKey(priv, crand.Reader))
+	return priv
+}
+
+func dsaFingerprint(pub *dsa.PublicKey) []byte {
+	fp := sha256.Sum256(encodeDSAPublicKey(pub))
+	return fp[:]
+}
+
+// TestAKEHandshake runs the AKE in ake.go honestly end to end and checks
+// both sides land on the same session key.
+func TestAKEHandshake(t *testing.T) {
+	alicePriv := genDSAKey(t)
+	bobPriv := genDSAKey(t)
+
+	alice := NewInitiator(alicePriv, dsaFingerprint(&bobPriv.PublicKey))
+	bob := NewResponder(bobPriv, dsaFingerprint(&alicePriv.PublicKey))
+
+	commit, err := alice.Commit()
+	assertNoError(t, err)
+
+	dhKey, err := bob.DHKey(commit)
+	assertNoError(t, err)
+
+	revealSig, err := alice.RevealSig(dhKey)
+	assertNoError(t, err)
+
+	sig, err := bob.Sig(revealSig)
+	assertNoError(t, err)
+
+	assertNoError(t, alice.VerifySig(sig))
+
+	assertTrue(t, bytes.Equal(alice.SessionKey, bob.SessionKey))
+}
+
+// TestAKERejectsParameterInjection reproduces the g=1/g=p/g=p-1 style
+// parameter injection from TestS5C35 against the AKE instead of raw DH: a
+// man in the middle forcing a degenerate public value, or simply lacking
+// the real peer's DSA key, makes the signed transcript stop matching and
+// the handshake aborts instead of silently succeeding.
+func TestAKERejectsParameterInjection(t *testing.T) {
+	alicePriv := genDSAKey(t)
+	bobPriv := genDSAKey(t)
+	bobFingerprint := dsaFingerprint(&bobPriv.PublicKey)
+
+	t.Run("degenerate g^y", func(t *testing.T) {
+		alice := NewInitiator(alicePriv, bobFingerprint)
+		bob := NewResponder(bobPriv, dsaFingerprint(&alicePriv.PublicKey))
+
+		commit, err := alice.Commit()
+		assertNoError(t, err)
+
+		_, err = bob.DHKey(commit)
+		assertNoError(t, err)
+
+		// A middleman replaces g^y with 1 in transit (the AKE analogue of
+		// injecting g = 1 into raw DH in TestS5C35).
+		forgedDHKey := akeEncode(big.NewInt(1).Bytes())
+
+		_, err = alice.RevealSig(forgedDHKey)
+		assertHasError(t, err)
+	})
+
+	t.Run("impersonator without the real peer's key", func(t *testing.T) {
+		alice := NewInitiator(alicePriv, bobFingerprint)
+		mitm := NewResponder(genDSAKey(t), dsaFingerprint(&alicePriv.PublicKey))
+
+		commit, err := alice.Commit()
+		assertNoError(t, err)
+
+		dhKey, err := mitm.DHKey(commit)
+		assertNoError(t, err)
+
+		revealSig, err := alice.RevealSig(dhKey)
+		assertNoError(t, err)
+
+		sig, err := mitm.Sig(revealSig)
+		assertNoError(t, err)
+
+		// Alice pinned Bob's real fingerprint, so the impersonator's own
+		// DSA key fails verification even though the DH exchange itself
+		// went through cleanly.
+		assertHasError(t, alice.VerifySig(sig))
+	})
+}
+
+// TestAKETranscriptKeysAreDirectionSeparated guards against a regression
+// where both sides of the AKE encrypt their signTranscript payload under
+// the same (c, m1, m2) derived straight from the symmetric DH secret s: on
+// an all-zero CTR IV, that means Alice's and Bob's RevealSig/Sig payloads
+// share one keystream, so a passive eavesdropper can XOR the two wire
+// messages' encrypted signatures together and recover
+// payloadAlice XOR payloadBob without ever touching s. Direction-separated
+// keys (c vs c') defeat that: the two sides' ciphertexts are no longer
+// related by a keystream XOR at all.
+func TestAKETranscriptKeysAreDirectionSeparated(t *testing.T) {
+	alicePriv := genDSAKey(t)
+	bobPriv := genDSAKey(t)
+
+	alice := NewInitiator(alicePriv, dsaFingerprint(&bobPriv.PublicKey))
+	bob := NewResponder(bobPriv, dsaFingerprint(&alicePriv.PublicKey))
+
+	commit, err := alice.Commit()
+	assertNoError(t, err)
+	dhKey, err := bob.DHKey(commit)
+	assertNoError(t, err)
+	revealSig, err := alice.RevealSig(dhKey)
+	assertNoError(t, err)
+	sig, err := bob.Sig(revealSig)
+	assertNoError(t, err)
+	assertNoError(t, alice.VerifySig(sig))
+
+	// The two directions' keys must actually differ, or this whole test is
+	// checking nothing.
+	assertFalse(t, bytes.Equal(alice.c, alice.cp))
+	assertFalse(t, bytes.Equal(alice.m1, alice.m1p))
+	assertFalse(t, bytes.Equal(alice.m2, alice.m2p))
+
+	// Pull the AES-CTR-encrypted signature payload out of each wire
+	// message: RevealSig wraps it as its third part, Sig's whole message
+	// is that part directly.
+	revealSigParts, err := akeDecode(revealSig, 3)
+	assertNoError(t, err)
+	aliceSigMsgParts, err := akeDecode(revealSigParts[2], 2)
+	assertNoError(t, err)
+	encSigAlice := aliceSigMsgParts[0]
+
+	bobSigMsgParts, err := akeDecode(sig, 2)
+	assertNoError(t, err)
+	encSigBob := bobSigMsgParts[0]
+
+	// A keystream-linked pair would have payloadAlice XOR payloadBob
+	// recoverable as encSigAlice XOR encSigBob with no key material at
+	// all. Decrypt each side's payload under the key that side actually
+	// used, then check that XORing the two ciphertexts no longer yields the
+	// XOR of the two plaintexts, as it would on a shared keystream.
+	plainAlice, err := aesCTRCrypt(alice.c, make([]byte, 16), encSigAlice)
+	assertNoError(t, err)
+	plainBob, err := aesCTRCrypt(bob.cp, make([]byte, 16), encSigBob)
+	assertNoError(t, err)
+
+	n := len(plainAlice)
+	if len(plainBob) < n {
+		n = len(plainBob)
+	}
+	ciphertextXOR := make([]byte, n)
+	plaintextXOR := make([]byte, n)
+	for i := 0; i < n; i++ {
+		ciphertextXOR[i] = encSigAlice[i] ^ encSigBob[i]
+		plaintextXOR[i] = plainAlice[i] ^ plainBob[i]
+	}
+
+	// Under the old single-key construction, ciphertextXOR == plaintextXOR
+	// by definition (both sides' keystreams cancel out). With
+	// direction-separated keys, the two ciphertexts were produced by
+	// unrelated keystreams, so that equality shouldn't hold.
+	assertFalse(t, bytes.Equal(ciphertextXOR, plaintextXOR))
+}
+
 func TestS5C35(t *testing.T) {
 	rand.Seed(time.Now().UnixNano())
 	plainText := []byte("Hi, I'm Alice!")
@@ -450,44 +641,32 @@ func TestS5C35(t *testing.T) {
 					// Echo message. Validate that we have keys.
 					assertTrue(t, authenticated)
 
-					// Decode encrypted message and IV
-					cipherTextHex, ok := msg.Params["message"]
-					assertTrue(t, ok)
-
-					cipherText, err := hex.DecodeString(cipherTextHex)
-					assertNoError(t, err)
-
-					ivHex, ok := msg.Params["iv"]
-					assertTrue(t, ok)
-
-					iv, err := hex.DecodeString(ivHex)
+					sealed, err := sealedMessageFromParams(msg.Params)
 					assertNoError(t, err)
-					assertEquals(t, 16, len(iv))
 
-					// Decrypt message
-					message, err := decryptAESCBC(cipherText, key, iv)
-					assertNoError(t, err)
-					unpaddedMessage, err := unpadPKCS7(message)
+					unpaddedMessage, err := OpenAESCBCHMAC(sealed, key)
 					if err != nil {
-						unpaddedMessage = []byte("PADDING ERROR")
+						// Authentication failed -- bad MAC and bad padding
+						// look identical on the wire now, so there's no
+						// oracle left to exploit the way there was before
+						// encrypt-then-MAC.
+						fmt.Println("echoBot (recv:decrypted):", err)
+						c <- RPC{Code: "EchoReply", Params: map[string]string{"error": err.Error()}}
+						continue
 					}
 					fmt.Println("echoBot (recv:decrypted):", string(unpaddedMessage))
 
-					// Encrypt with new IV and return to client
+					iv := make([]byte, 16)
 					_, err = rand.Read(iv)
 					assertNoError(t, err)
 
-					cipherText, err = encryptAESCBC(message, key, iv)
+					reply, err := SealAESCBCHMAC(unpaddedMessage, key, iv)
 					assertNoError(t, err)
 
 					c <- RPC{
-						Code: "EchoReply",
-						Params: map[string]string{
-							"message": hex.EncodeToString(cipherText),
-							"iv":      hex.EncodeToString(iv),
-						},
+						Code:   "EchoReply",
+						Params: paramsFromSealedMessage(reply),
 					}
-
 				}
 			}
 		}()
@@ -542,24 +721,10 @@ func TestS5C35(t *testing.T) {
 					// Echo message. Validate that we have keys.
 					assertTrue(t, authenticated)
 
-					// Decode encrypted message and IV
-					cipherTextHex, ok := msg.Params["message"]
-					assertTrue(t, ok)
-
-					cipherText, err := hex.DecodeString(cipherTextHex)
+					sealed, err := sealedMessageFromParams(msg.Params)
 					assertNoError(t, err)
 
-					ivHex, ok := msg.Params["iv"]
-					assertTrue(t, ok)
-
-					iv, err := hex.DecodeString(ivHex)
-					assertNoError(t, err)
-					assertEquals(t, 16, len(iv))
-
-					// Decrypt message
-					message, err := decryptAESCBC(cipherText, key, iv)
-					assertNoError(t, err)
-					unpaddedMessage, err := unpadPKCS7(message)
+					unpaddedMessage, err := OpenAESCBCHMAC(sealed, key)
 					assertNoError(t, err)
 					fmt.Println("middleMan (decrypted:client):", string(unpaddedMessage))
 					assertTrue(t, bytes.Equal(plainText, unpaddedMessage))
@@ -570,28 +735,15 @@ func TestS5C35(t *testing.T) {
 					// Decrypt echoServer reply
 					reply := <-echoChan
 
-					// Decode encrypted message and IV
-					cipherTextHex, ok = reply.Params["message"]
-					assertTrue(t, ok)
-
-					cipherText, err = hex.DecodeString(cipherTextHex)
-					assertNoError(t, err)
-
-					ivHex, ok = reply.Params["iv"]
-					assertTrue(t, ok)
-
-					iv, err = hex.DecodeString(ivHex)
-					assertNoError(t, err)
-					assertEquals(t, 16, len(iv))
-
-					// Decrypt message
-					message, err = decryptAESCBC(cipherText, key, iv)
-					assertNoError(t, err)
-					unpaddedMessage, err = unpadPKCS7(message)
-					if err != nil {
-						unpaddedMessage = []byte("PADDING ERROR")
+					if errMsg, failed := reply.Params["error"]; failed {
+						fmt.Println("middleMan (decrypted:echoBot):", errMsg)
+					} else if repliedSealed, err := sealedMessageFromParams(reply.Params); err == nil {
+						if unpaddedReply, err := OpenAESCBCHMAC(repliedSealed, key); err == nil {
+							fmt.Println("middleMan (decrypted:echoBot):", string(unpaddedReply))
+						} else {
+							fmt.Println("middleMan (decrypted:echoBot):", err)
+						}
 					}
-					fmt.Println("middleMan (decrypted:echoBot):", string(unpaddedMessage))
 
 					c <- reply
 				}
@@ -641,46 +793,30 @@ func TestS5C35(t *testing.T) {
 		key := md[:16]
 		fmt.Println("aliceBot key:", hex.EncodeToString(key))
 
-		// Pad message and send to Echo Server
-		message, err := padPKCS7ToBlockSize(plainText, 16)
-		assertNoError(t, err)
+		// Send message to Echo Server
 		iv := make([]byte, 16)
-		_, err = rand.Read(iv)
+		_, err := rand.Read(iv)
 		assertNoError(t, err)
 
-		cipherText, err := encryptAESCBC(message, key, iv)
+		sealed, err := SealAESCBCHMAC(plainText, key, iv)
 		assertNoError(t, err)
 
 		echoChan <- RPC{
-			Code: "Echo",
-			Params: map[string]string{
-				"message": hex.EncodeToString(cipherText),
-				"iv":      hex.EncodeToString(iv),
-			},
+			Code:   "Echo",
+			Params: paramsFromSealedMessage(sealed),
 		}
 
 		reply = <-echoChan
 		assertEquals(t, reply.Code, "EchoReply")
 
-		// Decode encrypted reply and IV
-		cipherTextHex, ok := reply.Params["message"]
-		assertTrue(t, ok)
-
-		cipherText, err = hex.DecodeString(cipherTextHex)
-		assertNoError(t, err)
-
-		ivHex, ok := reply.Params["iv"]
-		assertTrue(t, ok)
-
-		iv, err = hex.DecodeString(ivHex)
-		assertNoError(t, err)
-		assertEquals(t, 16, len(iv))
-
-		message, err = decryptAESCBC(cipherText, key, iv)
-		assertNoError(t, err)
-		unpaddedMessage, err := unpadPKCS7(message)
-		if err != nil {
-			unpaddedMessage = []byte("PADDING ERROR")
+		var unpaddedMessage []byte
+		if errMsg, failed := reply.Params["error"]; failed {
+			unpaddedMessage = []byte(errMsg)
+		} else {
+			repliedSealed, err := sealedMessageFromParams(reply.Params)
+			assertNoError(t, err)
+			unpaddedMessage, err = OpenAESCBCHMAC(repliedSealed, key)
+			assertNoError(t, err)
 		}
 
 		fmt.Println("aliceBot (recv:decrypted):", string(unpaddedMessage))
@@ -698,3 +834,97 @@ func TestS5C35(t *testing.T) {
 	mm := middleMan(echoChan)
 	aliceBot(mm)
 }
+
+// TestS5C34Ratchet drives the Ratchet type across many round trips, then
+// demonstrates forward secrecy: once a ratchet step has aged out of the
+// last-two window, the side that received it can no longer reconstruct the
+// key it decrypted with, even though the decrypt succeeded in the moment.
+func TestS5C34Ratchet(t *testing.T) {
+	alice, err := NewRatchet()
+	assertNoError(t, err)
+	bob, err := NewRatchet()
+	assertNoError(t, err)
+
+	aliceID, alicePub := alice.PublicValue()
+	bobID, bobPub := bob.PublicValue()
+	alice.SetPeerPublicValue(bobID, bobPub)
+	bob.SetPeerPublicValue(aliceID, alicePub)
+
+	const rounds = 6
+	var captured []RPC
+
+	for i := 0; i < rounds; i++ {
+		toBob := []byte(fmt.Sprintf("message %d from alice", i))
+		rpc, err := alice.Send(toBob)
+		assertNoError(t, err)
+		captured = append(captured, rpc)
+
+		got, err := bob.Recv(rpc)
+		assertNoError(t, err)
+		assertTrue(t, bytes.Equal(toBob, got))
+
+		toAlice := []byte(fmt.Sprintf("message %d from bob", i))
+		reply, err := bob.Send(toAlice)
+		assertNoError(t, err)
+
+		gotReply, err := alice.Recv(reply)
+		assertNoError(t, err)
+		assertTrue(t, bytes.Equal(toAlice, gotReply))
+	}
+
+	// Bob's own keypairs have long since ratcheted past the one the very
+	// first message from Alice was sealed against, so replaying it now
+	// fails -- Bob no longer holds the private key needed to rederive that
+	// message's key, so a compromise of his *current* session key can't
+	// reach back and decrypt it either.
+	_, err = bob.Recv(captured[0])
+	assertHasError(t, err)
+
+	// The MAC key that authenticated that first message should have been
+	// revealed once it aged out of Bob's retained window, the way OTR
+	// publishes spent MAC keys to support deniability.
+	assertTrue(t, len(bob.RevealedMACKeys) > 0)
+}
+
+// TestBigModExpCTMatchesBigModExp checks bigModExpCT's Montgomery ladder
+// against bigModExp (and so, transitively, big.Int.Exp) over the same
+// 1536-bit group the AKE/ratchet code runs in.
+func TestBigModExpCTMatchesBigModExp(t *testing.T) {
+	x, err := akeRandExponent()
+	assertNoError(t, err)
+	y, err := akeRandExponent()
+	assertNoError(t, err)
+
+	gx := bigModExp(akeG, x, akeP)
+	assertTrue(t, gx.Cmp(bigModExpCT(akeG, x, akeP)) == 0)
+
+	gxy := bigModExp(gx, y, akeP)
+	assertTrue(t, gxy.Cmp(bigModExpCT(gx, y, akeP)) == 0)
+}
+
+// BenchmarkBigModExp and BenchmarkBigModExpCT compare the Exp-backed
+// bigModExp against the Montgomery-ladder bigModExpCT over the same
+// 1536-bit group, to see what the constant-time guarantee costs.
+func BenchmarkBigModExp(b *testing.B) {
+	x, err := akeRandExponent()
+	if err != nil {
+		b.Fatalf("could not generate exponent: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bigModExp(akeG, x, akeP)
+	}
+}
+
+func BenchmarkBigModExpCT(b *testing.B) {
+	x, err := akeRandExponent()
+	if err != nil {
+		b.Fatalf("could not generate exponent: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bigModExpCT(akeG, x, akeP)
+	}
+}
@@ -0,0 +1,63 @@
+package cryptopals
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+)
+
+// TestIntNthRootPerfectPower checks IntNthRoot against a value constructed
+// to be an exact nth power, where remainder must be zero and exact true.
+func TestIntNthRootPerfectPower(t *testing.T) {
+	base := big.NewInt(123456789)
+	x := new(big.Int).Exp(base, big.NewInt(3), nil)
+
+	root, remainder, exact := IntNthRoot(x, 3)
+	assertTrue(t, root.Cmp(base) == 0)
+	assertTrue(t, remainder.Sign() == 0)
+	assertTrue(t, exact)
+}
+
+// TestIntNthRootNearPerfectPower checks that nudging a perfect cube just
+// above and just below its true root still lands on the correct floor,
+// with a nonzero remainder and exact reported false.
+func TestIntNthRootNearPerfectPower(t *testing.T) {
+	base := big.NewInt(123456789)
+	cube := new(big.Int).Exp(base, big.NewInt(3), nil)
+
+	above := new(big.Int).Add(cube, big.NewInt(17))
+	root, remainder, exact := IntNthRoot(above, 3)
+	assertTrue(t, root.Cmp(base) == 0)
+	assertTrue(t, remainder.Cmp(big.NewInt(17)) == 0)
+	assertFalse(t, exact)
+
+	below := new(big.Int).Sub(cube, big.NewInt(1))
+	root, _, exact = IntNthRoot(below, 3)
+	assertTrue(t, root.Cmp(new(big.Int).Sub(base, big.NewInt(1))) == 0)
+	assertFalse(t, exact)
+}
+
+// TestIntNthRoot2048Bit checks convergence on inputs sized for the RSA e=3
+// broadcast attack: a 2048-bit cube whose root is nowhere near the small
+// values cubeRoot's old halving search was tuned for.
+func TestIntNthRoot2048Bit(t *testing.T) {
+	base, err := rand.Prime(rand.Reader, 683)
+	assertNoError(t, err)
+	cube := new(big.Int).Exp(base, big.NewInt(3), nil)
+
+	root, remainder, exact := IntNthRoot(cube, 3)
+	assertTrue(t, root.Cmp(base) == 0)
+	assertTrue(t, remainder.Sign() == 0)
+	assertTrue(t, exact)
+}
+
+// TestCubeRootWrapsIntNthRoot shows cubeRoot still behaves the way the RSA
+// e=3 broadcast attack needs it to: IntNthRoot(i, 3) under another name.
+func TestCubeRootWrapsIntNthRoot(t *testing.T) {
+	base := big.NewInt(987654321)
+	cube := new(big.Int).Exp(base, big.NewInt(3), nil)
+
+	root, remainder := cubeRoot(cube)
+	assertTrue(t, root.Cmp(base) == 0)
+	assertTrue(t, remainder.Sign() == 0)
+}
@@ -0,0 +1,349 @@
+package cryptopals
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// SMP implements the Socialist Millionaires' Protocol as used by OTR to let
+// two parties who already share a DH session confirm they both hold the
+// same low-entropy secret, without revealing the secret to each other or to
+// a man in the middle who doesn't already know it. It follows the version-1
+// MODP construction from the OTR spec: a single safe-prime group with fixed
+// generator g1, two derived generators g2/g3, and Schnorr-style
+// zero-knowledge proofs tagged with distinct version bytes so a transcript
+// from one step can't be replayed as another.
+//
+// Usage: Alice calls Step1, sends the result to Bob. Bob calls Step2 with
+// Alice's message, sends the result back. Alice calls Step3, sends the
+// result to Bob. Bob calls Step4, sends the result to Alice and learns
+// whether the secrets matched. Alice calls Verify with Bob's Step4 message
+// to learn the same thing.
+type SMP struct {
+	p, q, g1 *big.Int
+	x        *big.Int
+
+	a2, a3   *big.Int
+	b3       *big.Int
+	g2, g3   *big.Int
+	g3a, g3b *big.Int
+	pa, pb   *big.Int
+	qa, qb   *big.Int
+}
+
+// NewSMP creates an SMP instance over the multiplicative group mod p (p must
+// be a safe prime, i.e. q = (p-1)/2 is also prime) with fixed generator g1 =
+// 2. sessionKey is the DH-derived key both parties are trying to confirm,
+// and secret is the low-entropy value both sides are expected to know (e.g.
+// a shared passphrase); they're hashed together into the SMP witness x so
+// that a successful match also certifies the session key.
+func NewSMP(p *big.Int, sessionKey, secret []byte) *SMP {
+	q := new(big.Int).Rsh(p, 1)
+
+	h := sha256.New()
+	h.Write(sessionKey)
+	h.Write(secret)
+	x := new(big.Int).Mod(new(big.Int).SetBytes(h.Sum(nil)), q)
+
+	return &SMP{p: p, q: q, g1: big.NewInt(2), x: x}
+}
+
+func smpRandExponent(q *big.Int) (*big.Int, error) {
+	// Exponents are drawn from [1, q) rather than [0, q) so they're never
+	// the trivial identity exponent.
+	r, err := rand.Int(rand.Reader, new(big.Int).Sub(q, big.NewInt(1)))
+	if err != nil {
+		return nil, err
+	}
+	return r.Add(r, big.NewInt(1)), nil
+}
+
+func smpHash(q *big.Int, version byte, ints ...*big.Int) *big.Int {
+	h := sha256.New()
+	h.Write([]byte{version})
+	for _, v := range ints {
+		h.Write(v.Bytes())
+	}
+	return new(big.Int).Mod(new(big.Int).SetBytes(h.Sum(nil)), q)
+}
+
+// smpProveDL produces a Schnorr proof of knowledge of exponent with
+// public == g^exponent mod p.
+func (s *SMP) smpProveDL(g, exponent *big.Int, version byte) (c, d *big.Int, err error) {
+	r, err := smpRandExponent(s.q)
+	if err != nil {
+		return nil, nil, err
+	}
+	gr := bigModExp(g, r, s.p)
+	c = smpHash(s.q, version, gr)
+	d = new(big.Int).Mod(new(big.Int).Sub(r, new(big.Int).Mul(exponent, c)), s.q)
+	return c, d, nil
+}
+
+func (s *SMP) smpVerifyDL(g, public, c, d *big.Int, version byte) bool {
+	gd := bigModExp(g, d, s.p)
+	pc := bigModExp(public, c, s.p)
+	combined := new(big.Int).Mod(new(big.Int).Mul(gd, pc), s.p)
+	return smpHash(s.q, version, combined).Cmp(c) == 0
+}
+
+// smpProvePQ produces the compound proof that P = g3^r and Q = g1^r * g2^x
+// for the same r, without revealing r or x.
+func (s *SMP) smpProvePQ(g2, g3, r, x *big.Int, version byte) (c, d1, d2 *big.Int, err error) {
+	r1, err := smpRandExponent(s.q)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	r2, err := smpRandExponent(s.q)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	t1 := bigModExp(g3, r1, s.p)
+	t2 := new(big.Int).Mod(new(big.Int).Mul(bigModExp(s.g1, r1, s.p), bigModExp(g2, r2, s.p)), s.p)
+	c = smpHash(s.q, version, t1, t2)
+	d1 = new(big.Int).Mod(new(big.Int).Sub(r1, new(big.Int).Mul(r, c)), s.q)
+	d2 = new(big.Int).Mod(new(big.Int).Sub(r2, new(big.Int).Mul(x, c)), s.q)
+	return c, d1, d2, nil
+}
+
+func (s *SMP) smpVerifyPQ(g2, g3, p, q, c, d1, d2 *big.Int, version byte) bool {
+	t1 := new(big.Int).Mod(new(big.Int).Mul(bigModExp(g3, d1, s.p), bigModExp(p, c, s.p)), s.p)
+	t2num := new(big.Int).Mul(bigModExp(s.g1, d1, s.p), bigModExp(g2, d2, s.p))
+	t2 := new(big.Int).Mod(new(big.Int).Mul(t2num, bigModExp(q, c, s.p)), s.p)
+	return smpHash(s.q, version, t1, t2).Cmp(c) == 0
+}
+
+// smpProveDLEq proves knowledge of exponent such that base1^exponent ==
+// public1 and base2^exponent == public2 (the same exponent in both).
+func (s *SMP) smpProveDLEq(base2, exponent *big.Int, version byte) (c, d *big.Int, err error) {
+	t, err := smpRandExponent(s.q)
+	if err != nil {
+		return nil, nil, err
+	}
+	t1 := bigModExp(s.g1, t, s.p)
+	t2 := bigModExp(base2, t, s.p)
+	c = smpHash(s.q, version, t1, t2)
+	d = new(big.Int).Mod(new(big.Int).Sub(t, new(big.Int).Mul(exponent, c)), s.q)
+	return c, d, nil
+}
+
+func (s *SMP) smpVerifyDLEq(public1, base2, public2, c, d *big.Int, version byte) bool {
+	t1 := new(big.Int).Mod(new(big.Int).Mul(bigModExp(s.g1, d, s.p), bigModExp(public1, c, s.p)), s.p)
+	t2 := new(big.Int).Mod(new(big.Int).Mul(bigModExp(base2, d, s.p), bigModExp(public2, c, s.p)), s.p)
+	return smpHash(s.q, version, t1, t2).Cmp(c) == 0
+}
+
+func smpEncode(ints ...*big.Int) []byte {
+	var out []byte
+	for _, v := range ints {
+		b := v.Bytes()
+		length := len(b)
+		out = append(out, byte(length>>24), byte(length>>16), byte(length>>8), byte(length))
+		out = append(out, b...)
+	}
+	return out
+}
+
+func smpDecode(data []byte, n int) ([]*big.Int, error) {
+	out := make([]*big.Int, 0, n)
+	for i := 0; i < n; i++ {
+		if len(data) < 4 {
+			return nil, errors.New("smp: truncated message")
+		}
+		length := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+		data = data[4:]
+		if len(data) < length {
+			return nil, errors.New("smp: truncated message")
+		}
+		out = append(out, new(big.Int).SetBytes(data[:length]))
+		data = data[length:]
+	}
+	if len(data) != 0 {
+		return nil, errors.New("smp: trailing bytes in message")
+	}
+	return out, nil
+}
+
+// Step1 is Alice's first move: pick a2, a3 and send g1^a2, g1^a3 together
+// with proofs of knowledge of a2 and a3.
+func (s *SMP) Step1() ([]byte, error) {
+	var err error
+	s.a2, err = smpRandExponent(s.q)
+	if err != nil {
+		return nil, err
+	}
+	s.a3, err = smpRandExponent(s.q)
+	if err != nil {
+		return nil, err
+	}
+
+	g2a := bigModExp(s.g1, s.a2, s.p)
+	g3a := bigModExp(s.g1, s.a3, s.p)
+	s.g3a = g3a
+
+	c2, d2, err := s.smpProveDL(s.g1, s.a2, 1)
+	if err != nil {
+		return nil, err
+	}
+	c3, d3, err := s.smpProveDL(s.g1, s.a3, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return smpEncode(g2a, c2, d2, g3a, c3, d3), nil
+}
+
+// Step2 is Bob's response: verify Alice's proofs, derive g2/g3, and send
+// back Bob's own g1^b2, g1^b3 plus the proof-carrying P/Q values.
+func (s *SMP) Step2(msg1 []byte) ([]byte, error) {
+	fields, err := smpDecode(msg1, 6)
+	if err != nil {
+		return nil, err
+	}
+	g2a, c2, d2, g3a, c3, d3 := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5]
+
+	if !s.smpVerifyDL(s.g1, g2a, c2, d2, 1) || !s.smpVerifyDL(s.g1, g3a, c3, d3, 2) {
+		return nil, errors.New("smp: step1 proof verification failed")
+	}
+	s.g3a = g3a
+
+	b2, err := smpRandExponent(s.q)
+	if err != nil {
+		return nil, err
+	}
+	s.b3, err = smpRandExponent(s.q)
+	if err != nil {
+		return nil, err
+	}
+
+	g2b := bigModExp(s.g1, b2, s.p)
+	g3b := bigModExp(s.g1, s.b3, s.p)
+	s.g3b = g3b
+
+	c2b, d2b, err := s.smpProveDL(s.g1, b2, 3)
+	if err != nil {
+		return nil, err
+	}
+	c3b, d3b, err := s.smpProveDL(s.g1, s.b3, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	s.g2 = bigModExp(g2a, b2, s.p)
+	s.g3 = bigModExp(g3a, s.b3, s.p)
+
+	r, err := smpRandExponent(s.q)
+	if err != nil {
+		return nil, err
+	}
+	s.pb = bigModExp(s.g3, r, s.p)
+	s.qb = new(big.Int).Mod(new(big.Int).Mul(bigModExp(s.g1, r, s.p), bigModExp(s.g2, s.x, s.p)), s.p)
+
+	cp, d5, d6, err := s.smpProvePQ(s.g2, s.g3, r, s.x, 5)
+	if err != nil {
+		return nil, err
+	}
+
+	return smpEncode(g2b, c2b, d2b, g3b, c3b, d3b, s.pb, s.qb, cp, d5, d6), nil
+}
+
+// Step3 is Alice's second move: verify Bob's proofs, derive g2/g3 on her
+// side, publish her own P/Q, and reveal Ra = (Qa/Qb)^a3 with a proof that
+// the same a3 from Step1 was used.
+func (s *SMP) Step3(msg2 []byte) ([]byte, error) {
+	fields, err := smpDecode(msg2, 11)
+	if err != nil {
+		return nil, err
+	}
+	g2b, c2b, d2b, g3b, c3b, d3b, pb, qb, cp, d5, d6 := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6], fields[7], fields[8], fields[9], fields[10]
+
+	if !s.smpVerifyDL(s.g1, g2b, c2b, d2b, 3) || !s.smpVerifyDL(s.g1, g3b, c3b, d3b, 4) {
+		return nil, errors.New("smp: step2 dl proof verification failed")
+	}
+	s.g3b = g3b
+
+	g2 := bigModExp(g2b, s.a2, s.p)
+	g3 := bigModExp(g3b, s.a3, s.p)
+	s.g2, s.g3 = g2, g3
+
+	if !s.smpVerifyPQ(g2, g3, pb, qb, cp, d5, d6, 5) {
+		return nil, errors.New("smp: step2 pq proof verification failed")
+	}
+	s.pb, s.qb = pb, qb
+
+	r, err := smpRandExponent(s.q)
+	if err != nil {
+		return nil, err
+	}
+	s.pa = bigModExp(g3, r, s.p)
+	s.qa = new(big.Int).Mod(new(big.Int).Mul(bigModExp(s.g1, r, s.p), bigModExp(g2, s.x, s.p)), s.p)
+
+	cp2, d7, d8, err := s.smpProvePQ(g2, g3, r, s.x, 6)
+	if err != nil {
+		return nil, err
+	}
+
+	qDiff := new(big.Int).Mod(new(big.Int).Mul(s.qa, new(big.Int).ModInverse(qb, s.p)), s.p)
+	ra := bigModExp(qDiff, s.a3, s.p)
+	cr, dr, err := s.smpProveDLEq(qDiff, s.a3, 7)
+	if err != nil {
+		return nil, err
+	}
+
+	return smpEncode(s.pa, s.qa, cp2, d7, d8, ra, cr, dr), nil
+}
+
+// Step4 is Bob's final move: verify Alice's proofs, reveal Rb = (Qa/Qb)^b3,
+// and compute whether the secrets matched. matched is only meaningful if
+// err is nil.
+func (s *SMP) Step4(msg3 []byte) (msg4 []byte, matched bool, err error) {
+	fields, err := smpDecode(msg3, 8)
+	if err != nil {
+		return nil, false, err
+	}
+	pa, qa, cp2, d7, d8, ra, cr, dr := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6], fields[7]
+
+	if !s.smpVerifyPQ(s.g2, s.g3, pa, qa, cp2, d7, d8, 6) {
+		return nil, false, errors.New("smp: step3 pq proof verification failed")
+	}
+
+	qDiff := new(big.Int).Mod(new(big.Int).Mul(qa, new(big.Int).ModInverse(s.qb, s.p)), s.p)
+	if !s.smpVerifyDLEq(s.g3a, qDiff, ra, cr, dr, 7) {
+		return nil, false, errors.New("smp: step3 r proof verification failed")
+	}
+	s.pa, s.qa = pa, qa
+
+	rb := bigModExp(qDiff, s.b3, s.p)
+	cr2, dr2, err := s.smpProveDLEq(qDiff, s.b3, 8)
+	if err != nil {
+		return nil, false, err
+	}
+
+	rab := bigModExp(ra, s.b3, s.p)
+	paOverPb := new(big.Int).Mod(new(big.Int).Mul(pa, new(big.Int).ModInverse(s.pb, s.p)), s.p)
+	matched = rab.Cmp(paOverPb) == 0
+
+	return smpEncode(rb, cr2, dr2), matched, nil
+}
+
+// Verify is Alice's final step: check Bob's revealed Rb against her own
+// view of Pa/Pb to decide whether the secrets matched.
+func (s *SMP) Verify(msg4 []byte) (bool, error) {
+	fields, err := smpDecode(msg4, 3)
+	if err != nil {
+		return false, err
+	}
+	rb, cr2, dr2 := fields[0], fields[1], fields[2]
+
+	qDiff := new(big.Int).Mod(new(big.Int).Mul(s.qa, new(big.Int).ModInverse(s.qb, s.p)), s.p)
+	if !s.smpVerifyDLEq(s.g3b, qDiff, rb, cr2, dr2, 8) {
+		return false, errors.New("smp: step4 r proof verification failed")
+	}
+
+	rab := bigModExp(rb, s.a3, s.p)
+	paOverPb := new(big.Int).Mod(new(big.Int).Mul(s.pa, new(big.Int).ModInverse(s.pb, s.p)), s.p)
+	return rab.Cmp(paOverPb) == 0, nil
+}